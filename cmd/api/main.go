@@ -1,12 +1,25 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"challenge-api/internal/consistency"
 	"challenge-api/internal/database"
+	"challenge-api/internal/idempotency"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/metrics"
+	"challenge-api/internal/ratelimit"
+	"challenge-api/internal/router"
 	"challenge-api/internal/server"
 	"challenge-api/internal/services"
+	"challenge-api/internal/stream"
+	"challenge-api/internal/tracing"
 
 	"github.com/joho/godotenv"
 )
@@ -14,28 +27,272 @@ import (
 func main() {
 	err := godotenv.Load()
 
+	logger := logging.New(os.Stdout, os.Getenv("LOG_LEVEL"))
+	slog.SetDefault(logger)
+
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		fatal("Error loading .env file", err)
 	}
 	cfg := server.Config{
-		Port: os.Getenv("PORT"),
+		Port:              os.Getenv("PORT"),
+		ReadTimeout:       envDuration("HTTP_READ_TIMEOUT", 10*time.Second),
+		ReadHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		WriteTimeout:      envDuration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		ShutdownTimeout:   envDuration("SHUTDOWN_TIMEOUT", 20*time.Second),
+		DrainDelay:        envDuration("SHUTDOWN_DRAIN_DELAY", 0),
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    os.Getenv("OTEL_TRACES_EXPORTER"),
+		ServiceName: envString("OTEL_SERVICE_NAME", "challenge-api"),
+	})
+	if err != nil {
+		fatal("Cannot set up tracing", err)
 	}
 
 	dsn := os.Getenv("DSN")
 
-	dbConn, err := database.ConnectPostgresDB(dsn)
+	poolConfig := database.PoolConfig{
+		MaxConns:          int32(envInt("DB_MAX_CONNS", 10)),
+		MinConns:          int32(envInt("DB_MIN_CONNS", 0)),
+		MaxConnLifetime:   envDuration("DB_MAX_CONN_LIFETIME", 5*time.Minute),
+		MaxConnIdleTime:   envDuration("DB_MAX_CONN_IDLE_TIME", 5*time.Minute),
+		HealthCheckPeriod: envDuration("DB_HEALTH_CHECK_PERIOD", time.Minute),
+	}
+	dbConn, err := database.ConnectPostgresDB(dsn, poolConfig, database.Backoff{
+		Attempts: envInt("DB_CONNECT_ATTEMPTS", 10),
+		Initial:  envDuration("DB_CONNECT_BACKOFF", 500*time.Millisecond),
+		Max:      envDuration("DB_CONNECT_MAX_BACKOFF", 10*time.Second),
+	})
 	if err != nil {
-		log.Fatal("Cannot connect do database ", err)
+		fatal("Cannot connect to database", err)
+	}
+	metrics.RegisterDBStats(dbConn.Pool, "primary")
+
+	clientKey := ratelimit.ClientKey(envBool("RATE_LIMIT_TRUST_PROXY", false))
+
+	// REPLICA_DSN holds one or more replica DSNs separated by "|", since a
+	// key/value DSN can't be split on commas or spaces.
+	var tracker *consistency.Tracker
+	if replicaDSNs := envList("REPLICA_DSN", "|"); len(replicaDSNs) > 0 {
+		dbConn.Replicas, err = database.ConnectReplicas(replicaDSNs, poolConfig, envDuration("REPLICA_MAX_LAG", 0))
+		if err != nil {
+			fatal("Cannot connect to replicas", err)
+		}
+		for _, r := range dbConn.Replicas.Replicas {
+			metrics.RegisterDBStats(r.Pool, r.Name)
+		}
+		tracker = &consistency.Tracker{
+			Window: envDuration("READ_YOUR_WRITES_WINDOW", 5*time.Second),
+			Key:    clientKey,
+		}
+	}
+
+	idempotencyStore := idempotency.NewStore(dbConn.Pool)
+
+	var rateLimitStore ratelimit.Store
+	switch store := envString("RATE_LIMIT_STORE", "memory"); store {
+	case "memory":
+		rateLimitStore = ratelimit.NewMemoryStore()
+	case "postgres":
+		rateLimitStore = ratelimit.NewPostgresStore(dbConn.Pool)
+	default:
+		fatal("Invalid RATE_LIMIT_STORE", fmt.Errorf("unknown store %q", store))
 	}
-	defer dbConn.DB.Close()
-	
+	hub := &stream.Hub{
+		Config: dbConn.Pool.Config().ConnConfig,
+		Retry: database.Backoff{
+			Initial: envDuration("DB_CONNECT_BACKOFF", 500*time.Millisecond),
+			Max:     envDuration("DB_CONNECT_MAX_BACKOFF", 10*time.Second),
+		},
+	}
+
+	cfg.Router = router.Config{
+		RateLimiter: &ratelimit.Limiter{
+			Store: rateLimitStore,
+			Key:   clientKey,
+		},
+		DefaultRateLimit: ratelimit.Limit{
+			Rate:  envFloat("RATE_LIMIT_RPS", 10),
+			Burst: envInt("RATE_LIMIT_BURST", 20),
+		},
+		StrictRateLimit: ratelimit.Limit{
+			Rate:  envFloat("RATE_LIMIT_STRICT_RPS", 0.1),
+			Burst: envInt("RATE_LIMIT_STRICT_BURST", 5),
+		},
+		Idempotency: &idempotency.Guard{
+			Store: idempotencyStore,
+			TTL:   envDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+			Scope: clientKey,
+		},
+		AdminToken:      os.Getenv("ADMIN_TOKEN"),
+		Consistency:     tracker,
+		SessionTTL:      envDuration("SESSION_TTL", 30*24*time.Hour),
+		Stream:          hub,
+		StreamHeartbeat: envPositiveDuration("STREAM_HEARTBEAT", 15*time.Second),
+	}
+
 	app := server.Application{
 		Config: cfg,
-		Models: services.New(dbConn.DB),
+		Models: services.New(dbConn.Pool, dbConn.Replicas),
+		DB:     dbConn,
 	}
+	streamRetention := envPositiveDuration("STREAM_EVENT_RETENTION", 24*time.Hour)
+	app.Background(func(ctx context.Context) {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := rateLimitStore.Cleanup(ctx, 10*time.Minute); err != nil {
+					slog.Warn("Error cleaning up rate limit buckets", "error", err)
+				}
+				if err := idempotencyStore.Cleanup(ctx); err != nil {
+					slog.Warn("Error cleaning up idempotency keys", "error", err)
+				}
+				tracker.Cleanup()
+				if err := app.Models.Sessions.Cleanup(ctx); err != nil {
+					slog.Warn("Error cleaning up expired sessions", "error", err)
+				}
+				if err := app.Models.Events.Cleanup(ctx, time.Now().Add(-streamRetention)); err != nil {
+					slog.Warn("Error cleaning up stream events", "error", err)
+				}
+			}
+		}
+	})
+	app.Background(hub.Run)
+	app.Background(func(ctx context.Context) {
+		dbConn.Replicas.Monitor(ctx, envDuration("REPLICA_CHECK_INTERVAL", 5*time.Second))
+	})
+	retention := envDuration("SOFT_DELETE_RETENTION", 30*24*time.Hour)
+	app.Background(func(ctx context.Context) {
+		var trash services.Trash
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, err := trash.Purge(ctx, time.Now().Add(-retention))
+				if err != nil {
+					slog.Error("Error purging deleted rows", "error", err)
+					continue
+				}
+				slog.Info("Purged deleted rows", "users", purged.Users, "posts", purged.Posts, "albums", purged.Albums, "comments", purged.Comments)
+			}
+		}
+	})
+
 	err = app.Serve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if tracingErr := shutdownTracing(ctx); tracingErr != nil {
+		slog.Error("Error flushing traces", "error", tracingErr)
+	}
 	if err != nil {
-		log.Fatal(err)
+		fatal("Server error", err)
 	}
 
-}
\ No newline at end of file
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// envString reads a variable from the environment, falling back to def when it
+// is unset.
+func envString(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envDuration reads a duration such as "15s" from the environment, falling
+// back to def when the variable is unset or invalid.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("Invalid duration, using default", "key", key, "value", v, "default", def.String())
+		return def
+	}
+	return d
+}
+
+// envPositiveDuration is envDuration for settings that must be greater than
+// zero, such as ticker periods, falling back to def otherwise.
+func envPositiveDuration(key string, def time.Duration) time.Duration {
+	d := envDuration(key, def)
+	if d <= 0 {
+		slog.Warn("Duration must be positive, using default", "key", key, "value", d.String(), "default", def.String())
+		return def
+	}
+	return d
+}
+
+// envInt reads an integer from the environment, falling back to def when the
+// variable is unset or invalid.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("Invalid integer, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// envFloat reads a number from the environment, falling back to def when the
+// variable is unset or invalid.
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("Invalid number, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return f
+}
+
+// envBool reads a boolean such as "true" or "1" from the environment,
+// falling back to def when the variable is unset or invalid.
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("Invalid boolean, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return b
+}
+
+// envList reads a list of values separated by sep from the environment,
+// skipping empty entries.
+func envList(key string, sep string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
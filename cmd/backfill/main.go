@@ -0,0 +1,83 @@
+// Command backfill indexes the posts written before hashtags and mentions
+// were tracked, so they show up in hashtag listings and trends and link the
+// users they mention. Nobody is notified of those old mentions. It works in
+// batches and can be run again, or resumed with -after from the last post ID
+// it logged.
+//
+//	go run ./cmd/backfill [-index hashtags,mentions] [-batch 500] [-after <post id>]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"challenge-api/internal/database"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+
+	"github.com/joho/godotenv"
+)
+
+// backfillFunc indexes a batch of posts, see services.Hashtag.BackfillHashtags.
+type backfillFunc func(ctx context.Context, after string, limit int) (string, error)
+
+func main() {
+	index := flag.String("index", "hashtags,mentions", "comma-separated indexes to backfill: hashtags, mentions")
+	batch := flag.Int("batch", 500, "posts indexed per batch")
+	after := flag.String("after", "", "resume after the post with this ID")
+	flag.Parse()
+
+	// The environment may also come from the shell rather than .env.
+	envErr := godotenv.Load()
+	slog.SetDefault(logging.New(os.Stdout, os.Getenv("LOG_LEVEL")))
+	if envErr != nil {
+		slog.Warn("Error loading .env file", "error", envErr)
+	}
+
+	dbConn, err := database.ConnectPostgresDB(os.Getenv("DSN"), database.PoolConfig{}, database.Backoff{
+		Attempts: 5,
+		Initial:  500 * time.Millisecond,
+		Max:      5 * time.Second,
+	})
+	if err != nil {
+		fatal("Cannot connect to database", err)
+	}
+	defer dbConn.Pool.Close()
+	models := services.New(dbConn.Pool, nil)
+
+	backfills := map[string]backfillFunc{
+		"hashtags": models.Hashtags.BackfillHashtags,
+		"mentions": models.Posts.BackfillMentions,
+	}
+	ctx := context.Background()
+	for _, name := range strings.Split(*index, ",") {
+		name = strings.TrimSpace(name)
+		backfill, ok := backfills[name]
+		if !ok {
+			fatal("Invalid -index", fmt.Errorf("unknown index %q", name))
+		}
+		last := *after
+		for {
+			next, err := backfill(ctx, last, *batch)
+			if err != nil {
+				fatal("Error indexing posts", err)
+			}
+			if next == "" {
+				break
+			}
+			last = next
+			slog.Info("Indexed posts", "index", name, "last_post_id", last)
+		}
+		slog.Info("Backfill done", "index", name)
+	}
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,31 @@
+// Package auth holds the authentication middleware of the API.
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"challenge-api/internal/helpers"
+)
+
+// RequireAdmin only lets through requests carrying token as a bearer
+// credential. An empty token disables the routes it guards.
+func RequireAdmin(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				helpers.ErrorJSON(w, errors.New("admin endpoints are disabled"), http.StatusForbidden)
+				return
+			}
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				helpers.ErrorJSON(w, errors.New("invalid admin credentials"), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+)
+
+var sessions services.Session
+
+type userKey struct{}
+
+// UserID returns the ID of the user authenticated by Authenticate, if any.
+func UserID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(userKey{}).(string)
+	return id, ok
+}
+
+// BearerToken returns the bearer credential of the request, or "".
+func BearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// Authenticate resolves the session token of the request, if any, and makes
+// the user available through UserID. Requests with an unknown or expired
+// token go through as anonymous; routes that need a user are guarded by
+// RequireUser.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := BearerToken(r)
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, err := sessions.Authenticate(r.Context(), token)
+		if errors.Is(err, services.ErrUnauthorized) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Error authenticating session", "error", err)
+			helpers.ErrorJSON(w, errors.New("cannot authenticate the request right now"), http.StatusServiceUnavailable)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userKey{}, id)
+		ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("user_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireUser only lets through requests authenticated by Authenticate.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserID(r.Context()); !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			helpers.ErrorJSON(w, errors.New("authentication required"), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
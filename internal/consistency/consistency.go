@@ -0,0 +1,79 @@
+// Package consistency gives clients read-your-writes consistency when reads
+// are served by replicas: for a while after a client changes data, its reads
+// go to the primary, so it never reads back a stale copy from a replica that
+// hasn't replayed the change yet.
+package consistency
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"challenge-api/internal/database"
+)
+
+// Tracker remembers when each client last wrote. Writes are tracked in
+// process memory, so behind a load balancer the window only holds on the
+// instance that served the write unless sessions are sticky.
+type Tracker struct {
+	// Window is how long a client's reads go to the primary after a write.
+	Window time.Duration
+	// Key identifies the client of a request.
+	Key func(r *http.Request) string
+
+	mu     sync.Mutex
+	writes map[string]time.Time
+}
+
+// Handler pins the reads of clients that wrote within Window to the primary
+// and records the writes of the others. A nil Tracker passes requests
+// through untouched.
+func (t *Tracker) Handler(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := t.Key(r)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if t.wroteRecently(key) {
+				r = r.WithContext(database.WithPrimary(r.Context()))
+			}
+			next.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+			t.recordWrite(key)
+		}
+	})
+}
+
+func (t *Tracker) wroteRecently(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last, ok := t.writes[key]
+	return ok && time.Since(last) < t.Window
+}
+
+func (t *Tracker) recordWrite(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.writes == nil {
+		t.writes = make(map[string]time.Time)
+	}
+	t.writes[key] = time.Now()
+}
+
+// Cleanup forgets the writes older than Window.
+func (t *Tracker) Cleanup() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := time.Now().Add(-t.Window)
+	for key, last := range t.writes {
+		if last.Before(cutoff) {
+			delete(t.writes, key)
+		}
+	}
+}
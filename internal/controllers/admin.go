@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// RestoreUser godoc
+// @Summary Restore a deleted user
+// @Description Undoes the soft deletion of a user and of the posts deleted with them. Requires the admin token.
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Param id path string true "User ID"
+// @Success 200 {object} Message
+// @Failure 404 {string} string "Not Found"
+// @Router /admin/users/{id}/restore [post]
+func RestoreUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := user.RestoreUser(r.Context(), id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error restoring user", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"message": "User restored successfully"}, nil)
+}
+
+// RestorePost godoc
+// @Summary Restore a deleted post
+// @Description Undoes the soft deletion of a post whose author is not deleted. Requires the admin token.
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Param id path string true "Post ID"
+// @Success 200 {object} Message
+// @Failure 404 {string} string "Not Found"
+// @Router /admin/posts/{id}/restore [post]
+func RestorePost(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := post.RestorePost(r.Context(), id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error restoring post", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"message": "Post restored successfully"}, nil)
+}
+
+// RestoreAlbum godoc
+// @Summary Restore a deleted album
+// @Description Undoes the soft deletion of an album. Requires the admin token.
+// @Tags admin
+// @Produce json
+// @Security AdminToken
+// @Param id path string true "Album ID"
+// @Success 200 {object} Message
+// @Failure 404 {string} string "Not Found"
+// @Router /admin/albums/{id}/restore [post]
+func RestoreAlbum(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := album.RestoreAlbum(r.Context(), id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error restoring album", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"message": "Album restored successfully"}, nil)
+}
@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"challenge-api/internal/auth"
 	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
 	"challenge-api/internal/services"
 	"encoding/json"
 	"net/http"
@@ -20,9 +22,9 @@ var album services.Album
 // @Success 200 {object} services.AlbumsList
 // @Router /albums [get]
 func GetAllAlbums( w http.ResponseWriter, r *http.Request) {
-	albums, err := album.GetAllAlbums()
+	albums, err := album.GetAllAlbums(r.Context())
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error getting all albums: ", err)
+		logging.FromContext(r.Context()).Error("Error getting all albums", "error", err)
 		return
 	}
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"albums": albums}, nil)
@@ -35,13 +37,18 @@ func GetAllAlbums( w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param id path string true "Album ID"
+// @Param If-None-Match header string false "ETag of the cached version"
 // @Success 200 {object} services.Album
+// @Success 304 "Not Modified"
 // @Router /albums/{id} [get]
 func GetAlbumByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	album, err := album.GetAlbumByID(id)
+	album, err := album.GetAlbumByID(r.Context(), id)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error getting album by id: ", err)
+		logging.FromContext(r.Context()).Error("Error getting album by id", "error", err)
+		return
+	}
+	if helpers.NotModified(w, r, album.UpdatedAt) {
 		return
 	}
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"album": album}, nil)
@@ -54,19 +61,20 @@ func GetAlbumByID(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param albumData body services.AlbumPayload true "Album Data"
+// @Param Idempotency-Key header string false "Key to safely retry the request"
 // @Success 201 {object} services.Album
 // @Router /albums [post]
 func CreateAlbum(w http.ResponseWriter, r *http.Request) {
 	var albumData services.Album
 	err := json.NewDecoder(r.Body).Decode(&albumData)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error parsing album: ", err)
+		logging.FromContext(r.Context()).Error("Error parsing album", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	albumCreated, err := album.CreateAlbum(albumData)
+	albumCreated, err := album.CreateAlbum(r.Context(), albumData)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error creating album: ", err)
+		logging.FromContext(r.Context()).Error("Error creating album", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 
 		return
@@ -82,41 +90,95 @@ func CreateAlbum(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path string true "Album ID"
 // @Param albumData body services.AlbumPayload true "Album Data"
+// @Param If-Match header string true "ETag of the version being replaced, or *"
 // @Success 200 {object} services.Album
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 428 {string} string "Precondition Required"
 // @Router /albums/{id} [put]
 func UpdateAlbum(w http.ResponseWriter, r *http.Request) {
 	var albumData services.Album
 	id := chi.URLParam(r, "id")
-	err := json.NewDecoder(r.Body).Decode(&albumData)
+	version, err := helpers.IfMatch(r)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error parsing album: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
 		return
 	}
-	albumUpdated, err := album.UpdateAlbum(id, albumData)
+	err = json.NewDecoder(r.Body).Decode(&albumData)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error updating album: ", err)
+		logging.FromContext(r.Context()).Error("Error parsing album", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"album": albumUpdated}, nil)
+	albumUpdated, err := album.UpdateAlbum(r.Context(), id, albumData, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error updating album", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"album": albumUpdated}, http.Header{"ETag": {helpers.ETag(albumUpdated.UpdatedAt)}})
+}
+
+// PatchAlbum godoc
+// @Summary Partially update an album
+// @Description Applies a JSON merge patch (RFC 7396) to the album identified by ID. Only the supplied fields are updated.
+// @Tags albums
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param id path string true "Album ID"
+// @Param If-Match header string true "ETag of the version being patched, or *"
+// @Param patch body services.AlbumPatch true "Merge patch"
+// @Success 200 {object} services.Album
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 415 {string} string "Unsupported Media Type"
+// @Failure 422 {string} string "Unprocessable Entity"
+// @Failure 428 {string} string "Precondition Required"
+// @Router /albums/{id} [patch]
+func PatchAlbum(w http.ResponseWriter, r *http.Request) {
+	var patch services.AlbumPatch
+	id := chi.URLParam(r, "id")
+	version, err := helpers.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
+		return
+	}
+	err = helpers.ReadMergePatch(w, r, &patch)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error parsing album patch", "error", err)
+		http.Error(w, err.Error(), helpers.PatchStatus(err))
+		return
+	}
+	albumPatched, err := album.PatchAlbum(r.Context(), id, patch, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error patching album", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"album": albumPatched}, http.Header{"ETag": {helpers.ETag(albumPatched.UpdatedAt)}})
 }
 
 // DeleteAlbum godoc
 // @Summary Delete an album
-// @Description Deletes an album entry identified by ID
+// @Description Soft-deletes an album entry identified by ID. An admin can restore it until it is purged.
 // @Tags albums
 // @Accept json
 // @Produce json
 // @Param id path string true "Album ID"
+// @Param If-Match header string true "ETag of the version being deleted, or *"
 // @Success 200 {object} Message
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 428 {string} string "Precondition Required"
 // @Router /albums/{id} [delete]
 func DeleteAlbum(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	err := album.DeleteAlbum(id)
+	version, err := helpers.IfMatch(r)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error deleting album: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
+		return
+	}
+	err = album.DeleteAlbum(r.Context(), id, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error deleting album", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
 		return
 	}
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"message": "Album deleted successfully"}, nil)
@@ -133,9 +195,9 @@ func DeleteAlbum(w http.ResponseWriter, r *http.Request) {
 // @Router /users/{id}/albums [get]
 func GetAlbumsByUserID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	albums, err := album.GetUserAlbums(id)
+	albums, err := album.GetUserAlbums(r.Context(), id)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error getting user albums: ", err)
+		logging.FromContext(r.Context()).Error("Error getting user albums", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -144,25 +206,29 @@ func GetAlbumsByUserID(w http.ResponseWriter, r *http.Request) {
 
 // AddAlbumToUser godoc
 // @Summary Add album to user
-// @Description Associates an album with a user
+// @Description Associates an album with a user. When an authenticated caller saves it for someone else, that user is notified that the album was shared with them.
 // @Tags albums
 // @Accept json
 // @Produce json
 // @Param userAlbumData body services.UserAlbumPayload true "User Album Data"
+// @Param Idempotency-Key header string false "Key to safely retry the request"
 // @Success 201 {object} services.UserAlbum
+// @Failure 404 {string} string "User or album not found"
+// @Failure 409 {string} string "Album already saved by the user"
 // @Router /albums/save [post]
 func AddAlbumToUser(w http.ResponseWriter, r *http.Request) {
 	var userAlbumData services.UserAlbum
 	err := json.NewDecoder(r.Body).Decode(&userAlbumData)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error decoding user album: ", err)
+		logging.FromContext(r.Context()).Error("Error decoding user album", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	albumAdded, err := album.AddAlbumToUser(userAlbumData)
+	actorID, _ := auth.UserID(r.Context())
+	albumAdded, err := album.AddAlbumToUser(r.Context(), userAlbumData, actorID)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error adding album to user: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logging.FromContext(r.Context()).Error("Error adding album to user", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
 		return
 	}
 	
@@ -182,9 +248,9 @@ func AddAlbumToUser(w http.ResponseWriter, r *http.Request) {
 func RemoveAlbumFromUser(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "id")
 	albumID:= chi.URLParam(r, "album_id")
-	err := album.RemoveAlbumFromUser(userID, albumID)
+	err := album.RemoveAlbumFromUser(r.Context(), userID, albumID)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error removing album from user: ", err)
+		logging.FromContext(r.Context()).Error("Error removing album from user", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
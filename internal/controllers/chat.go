@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"challenge-api/internal/stream"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// chatWriteWait bounds each write to a chat connection.
+	chatWriteWait = 10 * time.Second
+	// chatPongWait is how long a chat connection may stay silent; it is
+	// pinged a bit more often than that.
+	chatPongWait   = 60 * time.Second
+	chatPingPeriod = chatPongWait * 9 / 10
+	chatMaxFrame   = 16 << 10
+	// chatTypingEvery throttles the typing indicators relayed per
+	// conversation.
+	chatTypingEvery = 2 * time.Second
+)
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+var errUnknownCommand = errors.New(`type must be "message", "typing" or "read"`)
+
+// chats tracks the open chat connections. http.Server.Shutdown forgets
+// connections once they are hijacked, so CloseChats ends them before the
+// database goes away.
+var chats = struct {
+	sync.Mutex
+	conns   map[*websocket.Conn]struct{}
+	wg      sync.WaitGroup
+	closing bool
+}{conns: make(map[*websocket.Conn]struct{})}
+
+// trackChat registers conn, unless the server is shutting down.
+func trackChat(conn *websocket.Conn) bool {
+	chats.Lock()
+	defer chats.Unlock()
+	if chats.closing {
+		return false
+	}
+	chats.conns[conn] = struct{}{}
+	chats.wg.Add(1)
+	return true
+}
+
+func untrackChat(conn *websocket.Conn) {
+	chats.Lock()
+	delete(chats.conns, conn)
+	chats.Unlock()
+	chats.wg.Done()
+}
+
+// goingAway tells a chat client to reconnect, e.g. to another instance.
+func goingAway(conn *websocket.Conn) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "reconnect"), time.Now().Add(chatWriteWait))
+}
+
+// CloseChats tells the chat clients to reconnect, closes their connections
+// and waits, until ctx is done, for their handlers to stop, so no command
+// still runs when the database pool is closed. Chat connections are refused
+// from then on.
+func CloseChats(ctx context.Context) error {
+	chats.Lock()
+	chats.closing = true
+	// A slow client must not hold up the others.
+	for conn := range chats.conns {
+		go func(conn *websocket.Conn) {
+			goingAway(conn)
+			conn.Close()
+		}(conn)
+	}
+	chats.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		chats.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("chat connections did not close before the shutdown deadline")
+	}
+}
+
+// ChatCommand is a frame sent by a chat client: a message to send, a typing
+// indicator, or a read receipt. ClientID is echoed back in the reply.
+type ChatCommand struct {
+	Type           string `json:"type"`
+	ClientID       string `json:"client_id,omitempty"`
+	ConversationID string `json:"conversation_id"`
+	Content        string `json:"content,omitempty"`
+	MessageID      string `json:"message_id,omitempty"`
+}
+
+// ChatFrame is a frame sent to a chat client: an event of one of their
+// conversations ("message", "typing" or "read"), the reply to one of their
+// commands ("ack"), or a failed command ("error").
+type ChatFrame struct {
+	Type     string      `json:"type"`
+	ClientID string      `json:"client_id,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// Chat godoc
+// @Summary Chat over WebSocket
+// @Description Upgrades to a WebSocket delivering the caller's conversation activity as JSON frames {type, data}: "message" for new messages (including the caller's own, for their other devices), "typing" and "read" for read receipts. The client sends commands {type, client_id, conversation_id, ...}: "message" with content, "typing", and "read" with message_id. Commands are answered with an "ack" frame carrying the message or receipt, or an "error" frame, echoing client_id. The server pings every 54s and drops connections silent for 60s. Use the REST endpoints to load history.
+// @Tags conversations
+// @Security BearerAuth
+// @Success 101 "Switching Protocols"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /conversations/ws [get]
+func Chat(hub *stream.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := auth.UserID(r.Context())
+		logger := logging.FromContext(r.Context())
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade already answered the request.
+			logger.Warn("Error upgrading chat connection", "error", err)
+			return
+		}
+		if !trackChat(conn) {
+			goingAway(conn)
+			conn.Close()
+			return
+		}
+		// Runs last: once the connection is closed and the context canceled,
+		// the reader and the command it runs stop.
+		readDone := make(chan struct{})
+		defer func() {
+			<-readDone
+			untrackChat(conn)
+		}()
+		defer conn.Close()
+		sub := hub.Subscribe(userID, services.EventMessage, services.EventTyping, services.EventRead)
+		defer hub.Unsubscribe(sub)
+
+		// The request context isn't canceled when a hijacked client leaves.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		replies := make(chan ChatFrame, 16)
+		go func() {
+			defer close(readDone)
+			readChat(ctx, conn, userID, replies)
+		}()
+
+		ticker := time.NewTicker(chatPingPeriod)
+		defer ticker.Stop()
+		for {
+			var frame ChatFrame
+			select {
+			case <-readDone:
+				return
+			case <-sub.Done():
+				// Shutting down or fell behind: the client reconnects and
+				// reloads what it missed.
+				goingAway(conn)
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(chatWriteWait)); err != nil {
+					return
+				}
+				continue
+			case frame = <-replies:
+			case event := <-sub.Events:
+				frame = ChatFrame{Type: event.Type, Data: event.Data}
+			}
+			conn.SetWriteDeadline(time.Now().Add(chatWriteWait))
+			if err := conn.WriteJSON(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readChat runs the commands of a chat client until the connection fails,
+// handing their replies to the writer.
+func readChat(ctx context.Context, conn *websocket.Conn, userID string, replies chan<- ChatFrame) {
+	conn.SetReadLimit(chatMaxFrame)
+	conn.SetReadDeadline(time.Now().Add(chatPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(chatPongWait))
+	})
+
+	lastTyping := map[string]time.Time{}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(chatPongWait))
+
+		var cmd ChatCommand
+		var reply *ChatFrame
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			reply = &ChatFrame{Type: "error", Error: err.Error()}
+		} else if cmd.Type == services.EventTyping && time.Since(lastTyping[cmd.ConversationID]) < chatTypingEvery {
+			continue
+		} else {
+			if cmd.Type == services.EventTyping {
+				lastTyping[cmd.ConversationID] = time.Now()
+			}
+			reply = runChatCommand(ctx, userID, cmd)
+		}
+		if reply == nil {
+			continue
+		}
+		select {
+		case replies <- *reply:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runChatCommand runs one command of userID and returns the reply, if any.
+func runChatCommand(ctx context.Context, userID string, cmd ChatCommand) *ChatFrame {
+	var data interface{}
+	var err error
+	switch cmd.Type {
+	case services.EventMessage:
+		data, err = conversation.SendMessage(ctx, cmd.ConversationID, userID, services.MessagePayload{Content: cmd.Content})
+	case services.EventTyping:
+		err = conversation.SetTyping(ctx, cmd.ConversationID, userID)
+		if err == nil {
+			return nil
+		}
+	case services.EventRead:
+		data, err = conversation.MarkRead(ctx, cmd.ConversationID, userID, cmd.MessageID)
+	default:
+		err = errUnknownCommand
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("Error running chat command", "type", cmd.Type, "error", err)
+		return &ChatFrame{Type: "error", ClientID: cmd.ClientID, Error: err.Error()}
+	}
+	return &ChatFrame{Type: "ack", ClientID: cmd.ClientID, Data: data}
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+var comment services.Comment
+
+// GetComments godoc
+// @Summary List the comments of a post
+// @Description Lists one level of a post's comment threads, oldest first: the top-level comments, or the replies to parent_id. Deleted comments that still have replies are listed with empty content.
+// @Tags comments
+// @Produce json
+// @Param id path string true "Post ID"
+// @Param parent_id query string false "List the replies to this comment"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} services.CommentsList
+// @Failure 404 {string} string "Post not found"
+// @Router /posts/{id}/comments [get]
+func GetComments(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	page, err := pageFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var parentID *string
+	if v := r.URL.Query().Get("parent_id"); v != "" {
+		parentID = &v
+	}
+	comments, err := comment.GetComments(r.Context(), id, parentID, page)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting comments", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"comments": comments.Comments, "next_cursor": comments.NextCursor}, nil)
+}
+
+// CreateComment godoc
+// @Summary Comment on a post
+// @Description Adds a comment by the caller to a post, or a reply to one of its comments when parent_id is set
+// @Tags comments
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Post ID"
+// @Param commentData body services.CommentPayload true "Comment Data"
+// @Param Idempotency-Key header string false "Key to safely retry the request"
+// @Success 201 {object} services.Comment
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Post or parent comment not found"
+// @Failure 422 {string} string "Empty content"
+// @Router /posts/{id}/comments [post]
+func CreateComment(w http.ResponseWriter, r *http.Request) {
+	var commentData services.CommentPayload
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	err := json.NewDecoder(r.Body).Decode(&commentData)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error parsing comment", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	commentCreated, err := comment.CreateComment(r.Context(), id, userID, commentData)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error creating comment", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusCreated, helpers.Envelop{"comment": commentCreated}, http.Header{"ETag": {helpers.ETag(commentCreated.UpdatedAt)}})
+}
+
+// GetCommentByID godoc
+// @Summary Get comment by ID
+// @Description Retrieves a specific comment by its ID
+// @Tags comments
+// @Produce json
+// @Param id path string true "Comment ID"
+// @Param If-None-Match header string false "ETag of the cached version"
+// @Success 200 {object} services.Comment
+// @Success 304 "Not Modified"
+// @Failure 404 {string} string "Not Found"
+// @Router /comments/{id} [get]
+func GetCommentByID(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	found, err := comment.GetCommentByID(r.Context(), id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting comment by id", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	if helpers.NotModified(w, r, found.UpdatedAt) {
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"comment": found}, nil)
+}
+
+// UpdateComment godoc
+// @Summary Update a comment
+// @Description Replaces the content of a comment. Only its author may do so.
+// @Tags comments
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Comment ID"
+// @Param commentData body services.CommentPayload true "Comment Data"
+// @Param If-Match header string true "ETag of the version being replaced, or *"
+// @Success 200 {object} services.Comment
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Not the author"
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 428 {string} string "Precondition Required"
+// @Router /comments/{id} [put]
+func UpdateComment(w http.ResponseWriter, r *http.Request) {
+	var commentData services.CommentPayload
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	version, err := helpers.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
+		return
+	}
+	err = json.NewDecoder(r.Body).Decode(&commentData)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error parsing comment", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	commentUpdated, err := comment.UpdateComment(r.Context(), id, userID, commentData, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error updating comment", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"comment": commentUpdated}, http.Header{"ETag": {helpers.ETag(commentUpdated.UpdatedAt)}})
+}
+
+// DeleteComment godoc
+// @Summary Delete a comment
+// @Description Soft-deletes a comment. Only its author may do so. Replies to it stay in the thread.
+// @Tags comments
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Comment ID"
+// @Param If-Match header string true "ETag of the version being deleted, or *"
+// @Success 200 {object} Message
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Not the author"
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 428 {string} string "Precondition Required"
+// @Router /comments/{id} [delete]
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	version, err := helpers.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
+		return
+	}
+	err = comment.DeleteComment(r.Context(), id, userID, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error deleting comment", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"message": "Comment deleted successfully"}, nil)
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+var conversation services.Conversation
+
+// ReadPayload names the last message read in a conversation.
+type ReadPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// GetConversations godoc
+// @Summary List the caller's conversations
+// @Description Lists the caller's direct and group conversations, most recently active first, with their members, last message and unread count
+// @Tags conversations
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} services.ConversationsList
+// @Failure 401 {string} string "Unauthorized"
+// @Router /conversations [get]
+func GetConversations(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.UserID(r.Context())
+	page, err := pageFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := conversation.GetConversations(r.Context(), userID, page)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting conversations", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"conversations": list.Conversations, "next_cursor": list.NextCursor}, nil)
+}
+
+// CreateConversation godoc
+// @Summary Start a conversation
+// @Description Starts a conversation between the caller and member_ids. A single member without a title starts a direct conversation; if the two already have one, it is returned with 200 instead.
+// @Tags conversations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param conversationData body services.ConversationPayload true "Conversation Data"
+// @Success 200 {object} services.Conversation
+// @Success 201 {object} services.Conversation
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Member not found"
+// @Failure 422 {string} string "No other member or too many members"
+// @Router /conversations [post]
+func CreateConversation(w http.ResponseWriter, r *http.Request) {
+	var conversationData services.ConversationPayload
+	userID, _ := auth.UserID(r.Context())
+	err := json.NewDecoder(r.Body).Decode(&conversationData)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error parsing conversation", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conversationCreated, created, err := conversation.CreateConversation(r.Context(), userID, conversationData)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error creating conversation", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	helpers.WriteJSON(w, status, helpers.Envelop{"conversation": conversationCreated}, nil)
+}
+
+// GetConversationByID godoc
+// @Summary Get a conversation
+// @Description Retrieves one of the caller's conversations
+// @Tags conversations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Conversation ID"
+// @Success 200 {object} services.Conversation
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Not Found"
+// @Router /conversations/{id} [get]
+func GetConversationByID(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	found, err := conversation.GetConversationByID(r.Context(), id, userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting conversation by id", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"conversation": found}, nil)
+}
+
+// GetMessages godoc
+// @Summary List the messages of a conversation
+// @Description Lists the history of one of the caller's conversations, newest first
+// @Tags conversations
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Conversation ID"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} services.MessagesList
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Conversation not found"
+// @Router /conversations/{id}/messages [get]
+func GetMessages(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	page, err := pageFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := conversation.GetMessages(r.Context(), id, userID, page)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting messages", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"messages": list.Messages, "next_cursor": list.NextCursor}, nil)
+}
+
+// SendMessage godoc
+// @Summary Send a message
+// @Description Sends a message to one of the caller's conversations. Members connected to the chat WebSocket receive it live.
+// @Tags conversations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Conversation ID"
+// @Param messageData body services.MessagePayload true "Message Data"
+// @Param Idempotency-Key header string false "Key to safely retry the request"
+// @Success 201 {object} services.Message
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Conversation not found"
+// @Failure 422 {string} string "Empty content"
+// @Router /conversations/{id}/messages [post]
+func SendMessage(w http.ResponseWriter, r *http.Request) {
+	var messageData services.MessagePayload
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	err := json.NewDecoder(r.Body).Decode(&messageData)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error parsing message", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	message, err := conversation.SendMessage(r.Context(), id, userID, messageData)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error sending message", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusCreated, helpers.Envelop{"message": message}, nil)
+}
+
+// MarkConversationRead godoc
+// @Summary Mark a conversation as read
+// @Description Records that the caller read a conversation up to message_id and sends a read receipt to the members connected to the chat WebSocket
+// @Tags conversations
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Conversation ID"
+// @Param readData body ReadPayload true "Last message read"
+// @Success 200 {object} services.ReadReceipt
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Conversation or message not found"
+// @Router /conversations/{id}/read [post]
+func MarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	var readData ReadPayload
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	err := json.NewDecoder(r.Body).Decode(&readData)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error parsing read receipt", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	receipt, err := conversation.MarkRead(r.Context(), id, userID, readData.MessageID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error marking conversation read", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"receipt": receipt}, nil)
+}
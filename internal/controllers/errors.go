@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"challenge-api/internal/services"
+	"errors"
+	"net/http"
+)
+
+// statusFor maps the errors returned by the services to an HTTP status code,
+// falling back to def for anything it doesn't know.
+func statusFor(err error, def int) int {
+	switch {
+	case errors.Is(err, services.ErrNotFound), errors.Is(err, services.ErrInvalidReference):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInvalid):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, services.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, services.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrForbidden):
+		return http.StatusForbidden
+	}
+	return def
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"net/http"
+)
+
+var feed services.Feed
+
+// GetFeed godoc
+// @Summary Get the caller's feed
+// @Description Lists the posts of the users the caller follows, newest first
+// @Tags feed
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} services.PostsPage
+// @Failure 401 {string} string "Unauthorized"
+// @Router /feed [get]
+func GetFeed(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.UserID(r.Context())
+	page, err := pageFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := feed.GetFeed(r.Context(), userID, page)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting feed", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"posts": posts.Posts, "next_cursor": posts.NextCursor}, nil)
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+var follow services.Follow
+
+// FollowUser godoc
+// @Summary Follow a user
+// @Description Makes the caller follow the user. Following twice has no further effect.
+// @Tags follows
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} services.FollowStatus
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "User not found"
+// @Failure 422 {string} string "Cannot follow yourself"
+// @Router /users/{id}/follow [post]
+func FollowUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	status, err := follow.FollowUser(r.Context(), userID, id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error following user", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, status, nil)
+}
+
+// UnfollowUser godoc
+// @Summary Unfollow a user
+// @Description Makes the caller stop following the user, if they did
+// @Tags follows
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} services.FollowStatus
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "User not found"
+// @Router /users/{id}/follow [delete]
+func UnfollowUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	status, err := follow.UnfollowUser(r.Context(), userID, id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error unfollowing user", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, status, nil)
+}
+
+// GetFollowers godoc
+// @Summary List followers
+// @Description Lists the users following the user, most recent first
+// @Tags follows
+// @Produce json
+// @Param id path string true "User ID"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} services.FollowList
+// @Failure 404 {string} string "User not found"
+// @Router /users/{id}/followers [get]
+func GetFollowers(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, follow.GetFollowers)
+}
+
+// GetFollowing godoc
+// @Summary List followed users
+// @Description Lists the users the user follows, most recent first
+// @Tags follows
+// @Produce json
+// @Param id path string true "User ID"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} services.FollowList
+// @Failure 404 {string} string "User not found"
+// @Router /users/{id}/following [get]
+func GetFollowing(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, follow.GetFollowing)
+}
+
+func listFollows(w http.ResponseWriter, r *http.Request, list func(ctx context.Context, userID string, page services.Page) (*services.FollowList, error)) {
+	id := chi.URLParam(r, "id")
+	page, err := pageFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	users, err := list(r.Context(), id, page)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error listing follows", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"users": users.Users, "next_cursor": users.NextCursor}, nil)
+}
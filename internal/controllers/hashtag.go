@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+var hashtag services.Hashtag
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+	maxTrendingLimit      = 50
+)
+
+var errInvalidWindow = errors.New("window must be a duration between 1m and 168h, e.g. 24h")
+
+// GetPostsByHashtag godoc
+// @Summary List the posts with a hashtag
+// @Description Lists the posts tagged with a hashtag, newest first. Hashtags match regardless of case and accents, so "música" finds posts tagged #Musica.
+// @Tags hashtags
+// @Produce json
+// @Param tag path string true "Hashtag, without the #"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} services.PostsPage
+// @Router /hashtags/{tag}/posts [get]
+func GetPostsByHashtag(w http.ResponseWriter, r *http.Request) {
+	tag := chi.URLParam(r, "tag")
+	viewerID, _ := auth.UserID(r.Context())
+	page, err := pageFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := hashtag.GetPostsByHashtag(r.Context(), tag, viewerID, page)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting posts by hashtag", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"posts": posts.Posts, "next_cursor": posts.NextCursor}, nil)
+}
+
+// GetTrendingHashtags godoc
+// @Summary List trending hashtags
+// @Description Lists the hashtags used by the most posts created within the window
+// @Tags hashtags
+// @Produce json
+// @Param window query string false "How far back to look, as a duration (default 24h, max 168h)"
+// @Param limit query int false "Number of hashtags (default 10, max 50)"
+// @Success 200 {array} services.TrendingHashtag
+// @Failure 400 {string} string "Invalid window or limit"
+// @Router /hashtags/trending [get]
+func GetTrendingHashtags(w http.ResponseWriter, r *http.Request) {
+	window := defaultTrendingWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute || d > maxTrendingWindow {
+			http.Error(w, errInvalidWindow.Error(), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	limit := defaultTrendingLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, errInvalidLimit.Error(), http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxTrendingLimit)
+	}
+	trending, err := hashtag.Trending(r.Context(), time.Now().Add(-window), limit)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting trending hashtags", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"hashtags": trending}, nil)
+}
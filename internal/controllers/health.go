@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/services"
+	"net/http"
+	"sync/atomic"
+)
+
+var health services.Health
+
+var draining atomic.Bool
+
+// StartDraining makes the readiness probe fail so the orchestrator stops
+// routing traffic to this instance while in-flight requests finish.
+func StartDraining() {
+	draining.Store(true)
+}
+
+// Healthz is the liveness probe. It only reports that the process is able to
+// serve requests and does not check dependencies.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"status": "ok"}, nil)
+}
+
+// Readyz is the readiness probe. It checks the database connection and schema
+// version, reports connection pool statistics and fails while draining.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	report, ok := health.Ready(r.Context())
+	if draining.Load() {
+		report.Status = "draining"
+		ok = false
+	}
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	helpers.WriteJSON(w, status, report, nil)
+}
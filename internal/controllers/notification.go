@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+var notification services.Notification
+
+var errInvalidBefore = errors.New("before must be an RFC 3339 time")
+
+// GetNotifications godoc
+// @Summary List the caller's notifications
+// @Description Lists the caller's notifications, newest first, along with the number of unread ones
+// @Tags notifications
+// @Produce json
+// @Security BearerAuth
+// @Param unread query bool false "Only list unread notifications"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Success 200 {object} services.NotificationsList
+// @Failure 401 {string} string "Unauthorized"
+// @Router /notifications [get]
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.UserID(r.Context())
+	page, err := pageFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+	list, err := notification.GetNotifications(r.Context(), userID, unreadOnly, page)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting notifications", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"notifications": list.Notifications, "next_cursor": list.NextCursor, "unread_count": list.UnreadCount}, nil)
+}
+
+// GetUnreadCount godoc
+// @Summary Count the caller's unread notifications
+// @Description Returns the number of notifications the caller hasn't read, for badges and cheap polling
+// @Tags notifications
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]int
+// @Failure 401 {string} string "Unauthorized"
+// @Router /notifications/unread_count [get]
+func GetUnreadCount(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.UserID(r.Context())
+	count, err := notification.UnreadCount(r.Context(), userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error counting unread notifications", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"unread_count": count}, nil)
+}
+
+// MarkNotificationRead godoc
+// @Summary Mark a notification as read
+// @Tags notifications
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Notification ID"
+// @Success 200 {object} Message
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Not Found"
+// @Router /notifications/{id}/read [post]
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	err := notification.MarkRead(r.Context(), id, userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error marking notification read", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"message": "Notification marked as read"}, nil)
+}
+
+// MarkAllNotificationsRead godoc
+// @Summary Mark all notifications as read
+// @Description Marks the caller's unread notifications as read. Pass before (RFC 3339), e.g. the created_at of the newest notification shown, to leave the ones that arrived since unread.
+// @Tags notifications
+// @Produce json
+// @Security BearerAuth
+// @Param before query string false "Only mark notifications created up to this time"
+// @Success 200 {object} map[string]int64
+// @Failure 400 {string} string "Invalid before"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /notifications/read [post]
+func MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userID, _ := auth.UserID(r.Context())
+	var before time.Time
+	if v := r.URL.Query().Get("before"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			http.Error(w, errInvalidBefore.Error(), http.StatusBadRequest)
+			return
+		}
+		before = t
+	}
+	marked, err := notification.MarkAllRead(r.Context(), userID, before)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error marking notifications read", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"marked": marked}, nil)
+}
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"challenge-api/internal/services"
+)
+
+var errInvalidLimit = errors.New("limit must be a positive integer")
+
+// pageFrom reads the limit and cursor query parameters of a paginated list.
+func pageFrom(r *http.Request) (services.Page, error) {
+	page := services.Page{Cursor: r.URL.Query().Get("cursor")}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return page, errInvalidLimit
+		}
+		page.Limit = limit
+	}
+	return page, nil
+}
@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"challenge-api/internal/auth"
 	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
 	"challenge-api/internal/services"
 	"encoding/json"
 	"net/http"
@@ -20,9 +22,10 @@ var post services.Post
 // @Success 200 {object} services.PostsList
 // @Router /posts [get]
 func GetAllPosts(w http.ResponseWriter, r *http.Request)  {
-	posts, err := post.GetAllPosts()
+	viewerID, _ := auth.UserID(r.Context())
+	posts, err := post.GetAllPosts(r.Context(), viewerID)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error getting all posts: ", err)
+		logging.FromContext(r.Context()).Error("Error getting all posts", "error", err)
 		return
 	}
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"posts": posts}, nil)
@@ -36,15 +39,22 @@ func GetAllPosts(w http.ResponseWriter, r *http.Request)  {
 // @Produce json
 // @Param id path string true "Post ID"
 // @Success 200 {object} services.Post
+// @Failure 404 {string} string "Not Found"
 // @Router /posts/{id} [get]
 func GetPostByID(w http.ResponseWriter, r *http.Request)  {
 	id := chi.URLParam(r, "id")
-	post, err := post.GetPostByID(id)
+	viewerID, _ := auth.UserID(r.Context())
+	post, err := post.GetPostByID(r.Context(), id, viewerID)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error getting post by id: ", err)
+		logging.FromContext(r.Context()).Error("Error getting post by id", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusInternalServerError))
 		return
 	}
-	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"post": post}, nil)
+	// The ETag is the version to send back in If-Match. It doesn't cover the
+	// reactions, mentions and revision count, which change without bumping
+	// the version and depend on the viewer, so If-None-Match isn't honored.
+	w.Header().Add("Vary", "Authorization")
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"post": post}, http.Header{"ETag": {helpers.ETag(post.UpdatedAt)}})
 }
 
 // CreatePost godoc
@@ -54,20 +64,22 @@ func GetPostByID(w http.ResponseWriter, r *http.Request)  {
 // @Accept json
 // @Produce json
 // @Param postData body services.PostPayload true "Post Data"
+// @Param Idempotency-Key header string false "Key to safely retry the request"
 // @Success 201 {object} services.Post
+// @Failure 404 {string} string "User not found"
 // @Router /posts/create [post]
 func CreatePost(w http.ResponseWriter, r *http.Request)  {
 	var postData services.Post
 	err := json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error parsing post: ", err)
+		logging.FromContext(r.Context()).Error("Error parsing post", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	postCreated, err := post.CreatePost(postData)
+	postCreated, err := post.CreatePost(r.Context(), postData)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error creating post: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logging.FromContext(r.Context()).Error("Error creating post", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
 		return
 	}
 	helpers.WriteJSON(w, http.StatusCreated, helpers.Envelop{"post": postCreated}, nil)
@@ -81,41 +93,95 @@ func CreatePost(w http.ResponseWriter, r *http.Request)  {
 // @Produce json
 // @Param id path string true "Post ID"
 // @Param postData body services.PostPayload true "Post Data"
+// @Param If-Match header string true "ETag of the version being replaced, or *"
 // @Success 200 {object} services.Post
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 428 {string} string "Precondition Required"
 // @Router /posts/{id} [put]
 func UpdatePost(w http.ResponseWriter, r *http.Request)  {
 	var postData services.Post
 	id := chi.URLParam(r, "id")
-	err := json.NewDecoder(r.Body).Decode(&postData)
+	version, err := helpers.IfMatch(r)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error parsing post: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
 		return
 	}
-	postUpdated, err := post.UpdatePost(id, postData)
+	err = json.NewDecoder(r.Body).Decode(&postData)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error updating post: ", err)
+		logging.FromContext(r.Context()).Error("Error parsing post", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"post": postUpdated}, nil)
+	postUpdated, err := post.UpdatePost(r.Context(), id, postData, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error updating post", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"post": postUpdated}, http.Header{"ETag": {helpers.ETag(postUpdated.UpdatedAt)}})
+}
+
+// PatchPost godoc
+// @Summary Partially update a post
+// @Description Applies a JSON merge patch (RFC 7396) to the post identified by ID. Only the supplied fields are updated.
+// @Tags posts
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param id path string true "Post ID"
+// @Param If-Match header string true "ETag of the version being patched, or *"
+// @Param patch body services.PostPatch true "Merge patch"
+// @Success 200 {object} services.Post
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 415 {string} string "Unsupported Media Type"
+// @Failure 422 {string} string "Unprocessable Entity"
+// @Failure 428 {string} string "Precondition Required"
+// @Router /posts/{id} [patch]
+func PatchPost(w http.ResponseWriter, r *http.Request) {
+	var patch services.PostPatch
+	id := chi.URLParam(r, "id")
+	version, err := helpers.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
+		return
+	}
+	err = helpers.ReadMergePatch(w, r, &patch)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error parsing post patch", "error", err)
+		http.Error(w, err.Error(), helpers.PatchStatus(err))
+		return
+	}
+	postPatched, err := post.PatchPost(r.Context(), id, patch, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error patching post", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"post": postPatched}, http.Header{"ETag": {helpers.ETag(postPatched.UpdatedAt)}})
 }
 
 // DeletePost godoc
 // @Summary Delete a post
-// @Description Deletes a post entry identified by ID
+// @Description Soft-deletes a post entry identified by ID. An admin can restore it until it is purged.
 // @Tags posts
 // @Accept json
 // @Produce json
 // @Param id path string true "Post ID"
+// @Param If-Match header string true "ETag of the version being deleted, or *"
 // @Success 200 {object} Message
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 428 {string} string "Precondition Required"
 // @Router /posts/{id} [delete]
 func DeletePost(w http.ResponseWriter, r *http.Request)  {
 	id := chi.URLParam(r, "id")
-	err := post.DeletePost(id)
+	version, err := helpers.IfMatch(r)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error deleting post: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
+		return
+	}
+	err = post.DeletePost(r.Context(), id, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error deleting post", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
 		return
 	}
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"post": "deleted"}, nil)
@@ -132,9 +198,10 @@ func DeletePost(w http.ResponseWriter, r *http.Request)  {
 // @Router /users/{id}/posts [get]
 func GetPostsByUserID(w http.ResponseWriter, r *http.Request)  {
 	id := chi.URLParam(r, "id")
-	posts, err := post.GetPostsByUserID(id)
+	viewerID, _ := auth.UserID(r.Context())
+	posts, err := post.GetPostsByUserID(r.Context(), id, viewerID)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error getting posts by user id: ", err)
+		logging.FromContext(r.Context()).Error("Error getting posts by user id", "error", err)
 		return
 	}
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"posts": posts}, nil)
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+var reaction services.Reaction
+
+// ReactToPost godoc
+// @Summary React to a post
+// @Description Adds the caller's reaction of the given type to a post. Reacting twice with the same type has no further effect.
+// @Tags reactions
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Post ID"
+// @Param type path string true "Reaction type" Enums(like, love, haha, wow, sad, angry)
+// @Success 200 {object} services.ReactionSummary
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Post not found"
+// @Failure 422 {string} string "Unknown reaction type"
+// @Router /posts/{id}/reactions/{type} [put]
+func ReactToPost(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	summary, err := reaction.React(r.Context(), id, userID, chi.URLParam(r, "type"))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error reacting to post", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, summary, nil)
+}
+
+// RemoveReaction godoc
+// @Summary Remove a reaction from a post
+// @Description Removes the caller's reaction of the given type from a post, if they left one
+// @Tags reactions
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Post ID"
+// @Param type path string true "Reaction type" Enums(like, love, haha, wow, sad, angry)
+// @Success 200 {object} services.ReactionSummary
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Post not found"
+// @Failure 422 {string} string "Unknown reaction type"
+// @Router /posts/{id}/reactions/{type} [delete]
+func RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	summary, err := reaction.Unreact(r.Context(), id, userID, chi.URLParam(r, "type"))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error removing reaction", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, summary, nil)
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+)
+
+// GetPostRevisions godoc
+// @Summary Get the edit history of a post
+// @Description Lists the earlier versions of a post's content, newest first. Each revision's diff lists the word-level changes ("equal", "insert" or "delete" runs) made by the edit that replaced it, up to the next revision or the current content.
+// @Tags posts
+// @Produce json
+// @Param id path string true "Post ID"
+// @Success 200 {object} services.PostRevisionsList
+// @Failure 404 {string} string "Not Found"
+// @Router /posts/{id}/revisions [get]
+func GetPostRevisions(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	revisions, err := post.GetPostRevisions(r.Context(), id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error getting post revisions", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"revisions": revisions}, nil)
+}
+
+// RevertPost godoc
+// @Summary Revert a post to a revision
+// @Description Restores the content of an earlier revision of one of the caller's posts. The content it replaces is kept as a new revision.
+// @Tags posts
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Post ID"
+// @Param revision path int true "Revision number"
+// @Param If-Match header string true "ETag of the version being replaced, or *"
+// @Success 200 {object} services.Post
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 428 {string} string "Precondition Required"
+// @Router /posts/{id}/revisions/{revision}/revert [post]
+func RevertPost(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	userID, _ := auth.UserID(r.Context())
+	revision, err := strconv.Atoi(chi.URLParam(r, "revision"))
+	if err != nil {
+		http.Error(w, "invalid revision", http.StatusBadRequest)
+		return
+	}
+	version, err := helpers.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
+		return
+	}
+	postReverted, err := post.RevertPost(r.Context(), id, userID, revision, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error reverting post", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"post": postReverted}, http.Header{"ETag": {helpers.ETag(postReverted.UpdatedAt)}})
+}
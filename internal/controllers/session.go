@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+var session services.Session
+
+// Login godoc
+// @Summary Log in
+// @Description Exchanges a user's e-mail and password for a bearer token identifying them in later requests
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param credentials body services.Credentials true "Credentials"
+// @Success 201 {object} services.Session
+// @Failure 401 {string} string "Invalid credentials"
+// @Router /auth/login [post]
+func Login(ttl time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var creds services.Credentials
+		err := json.NewDecoder(r.Body).Decode(&creds)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Error parsing credentials", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		created, err := session.Login(r.Context(), creds, ttl)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("Error logging in", "error", err)
+			http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+			return
+		}
+		helpers.WriteJSON(w, http.StatusCreated, helpers.Envelop{"session": created}, http.Header{"Cache-Control": {"no-store"}})
+	}
+}
+
+// Logout godoc
+// @Summary Log out
+// @Description Ends the session of the bearer token
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} Message
+// @Failure 401 {string} string "Unauthorized"
+// @Router /auth/logout [post]
+func Logout(w http.ResponseWriter, r *http.Request) {
+	err := session.Logout(r.Context(), auth.BearerToken(r))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error logging out", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"message": "Logged out"}, nil)
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/services"
+	"challenge-api/internal/stream"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var events services.Events
+
+// Stream godoc
+// @Summary Stream events
+// @Description Server-Sent Events stream of the caller's new notifications ("notification" events) and of new posts by the users they follow ("post" events). Each event's id can be sent back as Last-Event-ID to resume after a disconnect; the stream then starts with the events missed meanwhile (up to 100, from the last 24h by default). Comment lines are sent as heartbeats. The server may end the stream, e.g. when shutting down or when the client falls behind, and clients should reconnect with Last-Event-ID.
+// @Tags stream
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param Last-Event-ID header string false "id of the last event received"
+// @Success 200 {string} string "Event stream"
+// @Failure 400 {string} string "Invalid Last-Event-ID"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /stream [get]
+func Stream(hub *stream.Hub, heartbeat time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := auth.UserID(r.Context())
+		rc := http.NewResponseController(w)
+		// The stream outlives the server's write timeout.
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			logging.FromContext(r.Context()).Error("Error disabling write deadline for stream", "error", err)
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		// Subscribe before catching up so nothing published meanwhile is
+		// lost; what both deliver is sent once.
+		sub := hub.Subscribe(userID, services.EventNotification, services.EventPost)
+		defer hub.Unsubscribe(sub)
+		missed, err := events.Since(r.Context(), userID, r.Header.Get("Last-Event-ID"))
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Error replaying stream events", "error", err)
+			http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		sent := make(map[string]bool, len(missed))
+		for _, event := range missed {
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			sent[event.ID] = true
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-sub.Done():
+				return
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case event := <-sub.Events:
+				if sent[event.ID] {
+					continue
+				}
+				if err := writeEvent(w, event); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event services.Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -2,14 +2,13 @@ package controllers
 
 import (
 	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
 	"challenge-api/internal/services"
 	"encoding/json"
 	"net/http"
 	"errors"
-	"database/sql"
 
 	"github.com/go-chi/chi"
-	"github.com/lib/pq"
 )
 
 type Message struct {
@@ -27,9 +26,9 @@ var user services.User
 // @Success 200 {object} services.UsersList
 // @Router /users [get]
 func GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := user.GetAllUsers()
+	users, err := user.GetAllUsers(r.Context())
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error getting all users: ", err)
+		logging.FromContext(r.Context()).Error("Error getting all users", "error", err)
 		return
 	}
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"users": users}, nil)
@@ -46,13 +45,16 @@ func GetAllUsers(w http.ResponseWriter, r *http.Request) {
 // @Router /users/{id} [get]
 func GetUserByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	user, err := user.GetUserByID(id)
+	user, err := user.GetUserByID(r.Context(), id)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error getting user by id: ", err)
+		logging.FromContext(r.Context()).Error("Error getting user by id", "error", err)
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
-	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"user": user}, nil)
+	// The ETag is the version to send back in If-Match. It doesn't cover the
+	// follow counts, which change without bumping the version, so
+	// If-None-Match isn't honored.
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"user": user}, http.Header{"ETag": {helpers.ETag(user.UpdatedAt)}})
 }
 
 // CreateUser godoc
@@ -62,7 +64,10 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param userData body services.UserPayload true "User Data"
+// @Param Idempotency-Key header string false "Key to safely retry the request"
 // @Success 201 {object} services.User
+// @Failure 409 {string} string "E-mail or user name already in use"
+// @Failure 422 {string} string "Missing required field"
 // @Failure 400 {object} Message
 // @Failure 409 {object} Message
 // @Router /users/create [post]
@@ -70,21 +75,15 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
     var userData services.User
     err := json.NewDecoder(r.Body).Decode(&userData)
     if err != nil {
-        helpers.MessageLogs.ErrorLog.Println("Error decoding user: ", err)
+        logging.FromContext(r.Context()).Error("Error decoding user", "error", err)
         http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
 
-    userCreated, err := user.CreateUser(userData)
+    userCreated, err := user.CreateUser(r.Context(), userData)
     if err != nil {
-        if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
-            // Error code 23505 corresponds to unique violation in PostgreSQL
-            helpers.MessageLogs.ErrorLog.Println("Error creating user - duplicate username: ", err)
-            http.Error(w, "Username already exists", http.StatusConflict)
-            return
-        }
-        helpers.MessageLogs.ErrorLog.Println("Error creating user: ", err)
-        http.Error(w, err.Error(), http.StatusBadRequest)
+        logging.FromContext(r.Context()).Error("Error creating user", "error", err)
+        http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
         return
     }
     helpers.WriteJSON(w, http.StatusCreated, helpers.Envelop{"user": userCreated}, nil)
@@ -98,42 +97,96 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path string true "User ID"
 // @Param userData body services.UserPayload true "User Data"
+// @Param If-Match header string true "ETag of the version being replaced, or *"
 // @Success 200 {object} services.User
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 428 {string} string "Precondition Required"
 // @Router /users/{id} [put]
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	var userData services.User
 	id := chi.URLParam(r, "id")
-	err := json.NewDecoder(r.Body).Decode(&userData)
+	version, err := helpers.IfMatch(r)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error decoding user: ", err)
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
+		return
+	}
+	err = json.NewDecoder(r.Body).Decode(&userData)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error decoding user", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	userUpdated, err := user.UpdateUser(id, userData)
+	userUpdated, err := user.UpdateUser(r.Context(), id, userData, version)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error updating user: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logging.FromContext(r.Context()).Error("Error updating user", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"user": userUpdated}, http.Header{"ETag": {helpers.ETag(userUpdated.UpdatedAt)}})
+}
+
+// PatchUser godoc
+// @Summary Partially update a user
+// @Description Applies a JSON merge patch (RFC 7396) to the user identified by ID. Only the supplied fields are updated.
+// @Tags users
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param If-Match header string true "ETag of the version being patched, or *"
+// @Param patch body services.UserPatch true "Merge patch"
+// @Success 200 {object} services.User
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 415 {string} string "Unsupported Media Type"
+// @Failure 422 {string} string "Unprocessable Entity"
+// @Failure 428 {string} string "Precondition Required"
+// @Router /users/{id} [patch]
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	var patch services.UserPatch
+	id := chi.URLParam(r, "id")
+	version, err := helpers.IfMatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
 		return
 	}
-	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"user": userUpdated}, nil)
+	err = helpers.ReadMergePatch(w, r, &patch)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error parsing user patch", "error", err)
+		http.Error(w, err.Error(), helpers.PatchStatus(err))
+		return
+	}
+	userPatched, err := user.PatchUser(r.Context(), id, patch, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error patching user", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
+		return
+	}
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"user": userPatched}, http.Header{"ETag": {helpers.ETag(userPatched.UpdatedAt)}})
 }
 
 // DeleteUser godoc
 // @Summary Delete a user
-// @Description Deletes a user identified by ID
+// @Description Soft-deletes a user identified by ID along with their posts. An admin can restore them until they are purged.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path string true "User ID"
+// @Param If-Match header string true "ETag of the version being deleted, or *"
 // @Success 200 {object} Message
+// @Failure 412 {string} string "Precondition Failed"
+// @Failure 428 {string} string "Precondition Required"
 // @Router /users/{id} [delete]
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	err := user.DeleteUser(id)
+	version, err := helpers.IfMatch(r)
 	if err != nil {
-		helpers.MessageLogs.ErrorLog.Println("Error deleting user: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), helpers.PreconditionStatus(err))
+		return
+	}
+	err = user.DeleteUser(r.Context(), id, version)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error deleting user", "error", err)
+		http.Error(w, err.Error(), statusFor(err, http.StatusBadRequest))
 		return
 	}
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"user": Message{"User deleted"}}, nil)
@@ -150,15 +203,16 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 // @Router /users/{username} [get]
 func GetUserByUsername(w http.ResponseWriter, r *http.Request) {
     username := chi.URLParam(r, "username")
-    user, err := user.GetUserByUsername(username)
+    user, err := user.GetUserByUsername(r.Context(), username)
     if err != nil {
-        if errors.Is(err, sql.ErrNoRows) {
+        if errors.Is(err, services.ErrNotFound) {
             http.Error(w, "User not found", http.StatusNotFound)
         } else {
-            helpers.MessageLogs.ErrorLog.Println("Error getting user by username: ", err)
+            logging.FromContext(r.Context()).Error("Error getting user by username", "error", err)
             http.Error(w, "Failed to get user", http.StatusInternalServerError)
         }
         return
     }
-    helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"user": user}, nil)
+    // As in GetUserByID, the follow counts aren't covered by the ETag.
+    helpers.WriteJSON(w, http.StatusOK, helpers.Envelop{"user": user}, http.Header{"ETag": {helpers.ETag(user.UpdatedAt)}})
 }
@@ -1,48 +1,134 @@
 package database
 
 import (
-	"database/sql"
-	"fmt"
+	"context"
+	"log/slog"
 	"time"
 
-	_ "github.com/jackc/pgconn"
-	_ "github.com/jackc/pgx/v4"
-	_ "github.com/jackc/pgx/v4/stdlib"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 )
+
+// Querier is the part of pgx statements are run on. *pgxpool.Pool, *pgx.Conn
+// and pgx.Tx all satisfy it, and so can an in-memory or test double.
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// Pool is a Querier that can also start transactions and be pinged, which is
+// what the services need from the database.
+type Pool interface {
+	Querier
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+	Ping(ctx context.Context) error
+}
+
 type DB struct {
-	DB *sql.DB
+	Pool *pgxpool.Pool
+	// Replicas serve read-only queries when configured. May be nil.
+	Replicas *ReplicaSet
+}
+
+// PoolConfig tunes the connection pool. Zero fields keep the value from the
+// DSN (e.g. pool_max_conns) or the pgxpool default.
+type PoolConfig struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
 }
 
 var dbConn = &DB{}
 
-const maxOpenConns = 10
-const maxIdleConns = 5
-const maxDbLifeTime = 5 * time.Minute
+const connectTimeout = 10 * time.Second
 
-func ConnectPostgresDB(dsn string) (*DB, error){
-	d, err := sql.Open("pgx", dsn)
+// ConnectPostgresDB opens the pool and pings the database, retrying with
+// backoff while the server can't be reached, e.g. when it is still starting.
+func ConnectPostgresDB(dsn string, cfg PoolConfig, retry Backoff) (*DB, error) {
+	config, err := poolConfig(dsn, cfg)
 	if err != nil {
 		return nil, err
 	}
-	d.SetMaxOpenConns(maxOpenConns)
-	d.SetMaxIdleConns(maxIdleConns)
-	d.SetConnMaxLifetime(maxDbLifeTime)
 
-	err = testDB(d)
+	ctx := context.Background()
+	for attempt := 1; ; attempt++ {
+		var p *pgxpool.Pool
+		p, err = connect(ctx, config)
+		if err == nil {
+			dbConn.Pool = p
+			return dbConn, nil
+		}
+		if attempt >= retry.Attempts || !Transient(ctx, err) {
+			return nil, err
+		}
+		delay := retry.Delay(attempt)
+		slog.Warn("Database not reachable, retrying", "attempt", attempt, "retry_in", delay.String(), "error", err)
+		time.Sleep(delay)
+	}
+}
+
+func connect(ctx context.Context, config *pgxpool.Config) (*pgxpool.Pool, error) {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	p, err := pgxpool.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
-	dbConn.DB = d
-	return dbConn, nil
+	err = testDB(ctx, p)
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
 }
 
-func testDB( d *sql.DB) error {
-	err := d.Ping()
+func poolConfig(dsn string, cfg PoolConfig) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConns > 0 {
+		config.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		config.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+	return config, nil
+}
+
+func testDB(ctx context.Context, p *pgxpool.Pool) error {
+	err := p.Ping(ctx)
 	if err != nil {
-		fmt.Println("Error pinging database", err)
 		return err
 	}
-	fmt.Println("*** Pinged database successfully ***")
+	slog.Info("Pinged database successfully", "max_conns", p.Config().MaxConns)
 	return nil
-}
\ No newline at end of file
+}
+
+type primaryKey struct{}
+
+// WithPrimary marks ctx so the queries run with it read from the primary even
+// when they could be served by a replica.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// PrimaryRequired reports whether ctx was marked by WithPrimary.
+func PrimaryRequired(ctx context.Context) bool {
+	required, _ := ctx.Value(primaryKey{}).(bool)
+	return required
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Replica is a connection pool to a read replica.
+type Replica struct {
+	Name string
+	Pool *pgxpool.Pool
+
+	healthy atomic.Bool
+}
+
+func (r *Replica) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// MarkDown takes the replica out of rotation until its next successful health
+// check, e.g. after a query on it failed to reach the server.
+func (r *Replica) MarkDown(err error) {
+	if r.healthy.Swap(false) {
+		slog.Warn("Replica marked down, reading from primary", "replica", r.Name, "error", err)
+	}
+}
+
+func (r *Replica) markUp() {
+	if !r.healthy.Swap(true) {
+		slog.Info("Replica back in rotation", "replica", r.Name)
+	}
+}
+
+// ReplicaSet spreads reads over read replicas round-robin, skipping the ones
+// that are down. A nil *ReplicaSet has no replicas.
+type ReplicaSet struct {
+	Replicas []*Replica
+	// MaxLag takes a replica out of rotation while it is further behind the
+	// primary than this. Zero disables the check.
+	MaxLag time.Duration
+
+	next atomic.Uint32
+}
+
+// ConnectReplicas opens a pool per replica DSN. The pools connect lazily so a
+// replica that is down at startup doesn't keep the API from starting; it
+// joins the rotation once Monitor finds it healthy.
+func ConnectReplicas(dsns []string, cfg PoolConfig, maxLag time.Duration) (*ReplicaSet, error) {
+	set := &ReplicaSet{MaxLag: maxLag}
+	for i, dsn := range dsns {
+		config, err := poolConfig(dsn, cfg)
+		if err != nil {
+			set.Close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		config.LazyConnect = true
+		p, err := pgxpool.ConnectConfig(context.Background(), config)
+		if err != nil {
+			set.Close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		set.Replicas = append(set.Replicas, &Replica{Name: fmt.Sprintf("replica-%d", i+1), Pool: p})
+	}
+	return set, nil
+}
+
+// Pick returns the next healthy replica, or nil when there is none and reads
+// should go to the primary.
+func (s *ReplicaSet) Pick() *Replica {
+	if s == nil || len(s.Replicas) == 0 {
+		return nil
+	}
+	start := s.next.Add(1)
+	for i := range s.Replicas {
+		r := s.Replicas[(int(start)+i)%len(s.Replicas)]
+		if r.Healthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+// Monitor checks every replica each interval until ctx is canceled, putting
+// the ones that answer, and aren't lagging too far behind, in rotation.
+func (s *ReplicaSet) Monitor(ctx context.Context, interval time.Duration) {
+	if s == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, r := range s.Replicas {
+			if err := s.check(ctx, r); err != nil {
+				r.MarkDown(err)
+			} else {
+				r.markUp()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ReplicaSet) check(ctx context.Context, r *Replica) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	if err := r.Pool.Ping(ctx); err != nil {
+		return err
+	}
+	if s.MaxLag <= 0 {
+		return nil
+	}
+	// The replay timestamp is NULL when the server isn't replaying, in which
+	// case there is no lag to speak of.
+	var lag float64
+	query := `SELECT COALESCE(EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp()), 0)`
+	if err := r.Pool.QueryRow(ctx, query).Scan(&lag); err != nil {
+		return err
+	}
+	if d := time.Duration(lag * float64(time.Second)); d > s.MaxLag {
+		return fmt.Errorf("replication lag of %s exceeds %s", d.Round(time.Millisecond), s.MaxLag)
+	}
+	return nil
+}
+
+func (s *ReplicaSet) Close() {
+	if s == nil {
+		return
+	}
+	for _, r := range s.Replicas {
+		r.Pool.Close()
+	}
+}
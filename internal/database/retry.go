@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// Backoff spaces out retries exponentially, with full jitter so that
+// instances restarted together don't retry in lockstep.
+type Backoff struct {
+	// Attempts is the total number of tries, the first one included.
+	Attempts int
+	// Initial caps the delay before the first retry; the cap doubles with
+	// every retry up to Max.
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay returns how long to wait before retry number retry, counting from 1.
+func (b Backoff) Delay(retry int) time.Duration {
+	ceiling := b.Initial
+	for i := 1; i < retry && ceiling < b.Max; i++ {
+		ceiling *= 2
+	}
+	if ceiling > b.Max {
+		ceiling = b.Max
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// Wait sleeps for the delay before retry number retry, or until ctx ends.
+func (b Backoff) Wait(ctx context.Context, retry int) error {
+	timer := time.NewTimer(b.Delay(retry))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Transient reports whether err is a connection failure worth retrying: the
+// server couldn't be reached, dropped the connection, or is still starting
+// up. Errors caused by ctx ending don't count.
+func Transient(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection_exception; 57P03 is cannot_connect_now,
+		// returned while the server starts up or recovers.
+		return pgErr.Code[:2] == "08" || pgErr.Code == "57P03"
+	}
+	var netErr net.Error
+	return pgconn.SafeToRetry(err) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
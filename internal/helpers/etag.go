@@ -0,0 +1,77 @@
+package helpers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPreconditionRequired = errors.New("If-Match header is required")
+	ErrInvalidETag          = errors.New("If-Match does not match the current version")
+)
+
+// ETag returns a strong entity tag derived from the resource's update time,
+// which Postgres stores with microsecond precision.
+func ETag(updatedAt time.Time) string {
+	return `"` + strconv.FormatInt(updatedAt.UnixMicro(), 36) + `"`
+}
+
+// parseETag converts a tag produced by ETag back to the update time.
+func parseETag(tag string) (time.Time, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return time.Time{}, false
+	}
+	micros, err := strconv.ParseInt(tag[1:len(tag)-1], 36, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(micros), true
+}
+
+// IfMatch returns the version required by the request's If-Match header. A
+// zero time means "*", i.e. any existing version. Weak tags never match.
+func IfMatch(r *http.Request) (time.Time, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return time.Time{}, ErrPreconditionRequired
+	}
+	if header == "*" {
+		return time.Time{}, nil
+	}
+	version, ok := parseETag(header)
+	if !ok {
+		return time.Time{}, ErrInvalidETag
+	}
+	return version, nil
+}
+
+// NotModified sets the ETag header and, when the request's If-None-Match
+// header matches it, writes 304 Not Modified and returns true.
+func NotModified(w http.ResponseWriter, r *http.Request, updatedAt time.Time) bool {
+	etag := ETag(updatedAt)
+	w.Header().Set("ETag", etag)
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// PreconditionStatus maps the errors returned by IfMatch to their status code.
+func PreconditionStatus(err error) int {
+	if errors.Is(err, ErrPreconditionRequired) {
+		return http.StatusPreconditionRequired
+	}
+	return http.StatusPreconditionFailed
+}
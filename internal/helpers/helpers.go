@@ -1,30 +1,17 @@
 package helpers
 
 import (
+	"bytes"
 	"challenge-api/internal/services"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"mime"
 	"net/http"
-	"os"
 )
 
 type Envelop map[string]interface{}
 
-type Message struct {
-	InfoLog *log.Logger
-	ErrorLog  *log.Logger
-}
-
-var infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-
-var errorLog = log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-
-var MessageLogs = Message{
-	InfoLog: infoLog,
-	ErrorLog:  errorLog,
-}
-
 func ReadJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
 	maxByte := 1048576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxByte))
@@ -69,4 +56,53 @@ func ErrorJSON ( w http.ResponseWriter, err error, status ...int) {
 	payload.Error = true
 	payload.Message = err.Error()
 	WriteJSON(w, statusCode, payload, nil)
-}
\ No newline at end of file
+}
+
+var (
+	ErrUnsupportedMediaType = errors.New("Content-Type must be application/merge-patch+json")
+	ErrInvalidPatch         = errors.New("invalid merge patch")
+)
+
+// ReadMergePatch decodes an RFC 7396 JSON merge patch into dst, whose fields
+// should be pointers so that omitted members stay nil. Members set to null
+// are rejected since none of the patchable fields can be removed, and so are
+// members dst doesn't have.
+func ReadMergePatch(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		return ErrUnsupportedMediaType
+	}
+	var raw json.RawMessage
+	err := ReadJSON(w, r, &raw)
+	if err != nil {
+		return err
+	}
+	var members map[string]json.RawMessage
+	err = json.Unmarshal(raw, &members)
+	if err != nil || members == nil {
+		return fmt.Errorf("%w: must be a JSON object", ErrInvalidPatch)
+	}
+	for name, value := range members {
+		if string(value) == "null" {
+			return fmt.Errorf("%w: field %q cannot be removed", ErrInvalidPatch, name)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(dst)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return nil
+}
+
+// PatchStatus maps the errors returned by ReadMergePatch to their status code.
+func PatchStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrInvalidPatch):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
+}
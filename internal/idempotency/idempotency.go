@@ -0,0 +1,174 @@
+// Package idempotency lets clients safely retry non-idempotent requests by
+// sending an Idempotency-Key header: the first response is stored and
+// replayed for every retry carrying the same key and body.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	maxBodyBytes = 1048576
+
+	// settleTimeout bounds storing the response of a request, or releasing
+	// its key, once the handler ran.
+	settleTimeout = 5 * time.Second
+)
+
+// replayedHeaders are the response headers stored alongside the body.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// Guard is middleware enforcing idempotency keys on the routes it wraps.
+type Guard struct {
+	Store *Store
+	// TTL is how long a stored response is replayed.
+	TTL time.Duration
+	// Scope identifies the client so keys from different clients never
+	// collide.
+	Scope func(r *http.Request) string
+}
+
+// Handler wraps next. Requests without an Idempotency-Key header pass
+// through unchanged. A nil Guard disables the middleware.
+func (g *Guard) Handler(next http.Handler) http.Handler {
+	if g == nil || g.Store == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(HeaderKey)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(header) > maxKeyLength {
+			helpers.ErrorJSON(w, errors.New("Idempotency-Key must be at most 255 characters"), http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			helpers.ErrorJSON(w, err, http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := g.Scope(r) + ":" + r.Method + ":" + r.URL.Path + ":" + header
+		fp := fingerprint(r.Method, r.URL.Path, body)
+		logger := logging.FromContext(r.Context())
+
+		rec, acquired, err := g.Store.Acquire(r.Context(), key, fp, g.TTL)
+		if err != nil {
+			logger.Error("Error acquiring idempotency key", "error", err)
+			helpers.ErrorJSON(w, errors.New("could not process Idempotency-Key"), http.StatusInternalServerError)
+			return
+		}
+		if !acquired {
+			switch {
+			case rec.Fingerprint != fp:
+				helpers.ErrorJSON(w, errors.New("Idempotency-Key was already used with a different request body"), http.StatusUnprocessableEntity)
+			case !rec.Completed():
+				w.Header().Set("Retry-After", "1")
+				helpers.ErrorJSON(w, errors.New("a request with this Idempotency-Key is still being processed"), http.StatusConflict)
+			default:
+				replay(w, rec)
+			}
+			return
+		}
+
+		// Once the handler ran, its writes are done whether or not the client
+		// is still there, so the key is settled even when the request was
+		// canceled. Otherwise it would stay locked until lockTimeout, and then
+		// a retry would run the request again.
+		settle := func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.WithoutCancel(r.Context()), settleTimeout)
+		}
+
+		cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// The handler failed or panicked: let the client retry.
+			ctx, cancel := settle()
+			defer cancel()
+			if err := g.Store.Release(ctx, key); err != nil {
+				logger.Error("Error releasing idempotency key", "key", header, "error", err)
+			}
+		}()
+
+		next.ServeHTTP(cw, r)
+
+		if cw.status >= http.StatusInternalServerError {
+			return
+		}
+		headers := make(map[string]string)
+		for _, h := range replayedHeaders {
+			if v := cw.Header().Get(h); v != "" {
+				headers[h] = v
+			}
+		}
+		ctx, cancel := settle()
+		defer cancel()
+		err = g.Store.Complete(ctx, key, cw.status, headers, cw.body.Bytes())
+		if err != nil {
+			logger.Error("Error storing idempotent response", "key", header, "error", err)
+			return
+		}
+		completed = true
+	})
+}
+
+func fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, method+" "+path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, rec *Record) {
+	for k, v := range rec.Headers {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.Header().Set("Content-Length", strconv.Itoa(len(rec.Body)))
+	w.WriteHeader(rec.StatusCode)
+	w.Write(rec.Body)
+}
+
+// captureWriter copies the response into memory while writing it through.
+type captureWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.status = status
+		c.wroteHeader = true
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	c.wroteHeader = true
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
@@ -0,0 +1,108 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// fakeDB answers the statements of Store like Postgres would for a key seen
+// for the first time, failing once their context is done.
+type fakeDB struct {
+	t        *testing.T
+	released bool
+	status   int
+	body     []byte
+}
+
+func (d *fakeDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.Contains(sql, "INSERT INTO idempotency_keys"):
+		return pgconn.CommandTag("INSERT 0 1"), nil
+	case strings.Contains(sql, "UPDATE idempotency_keys"):
+		d.status = args[0].(int)
+		d.body = args[2].([]byte)
+		return pgconn.CommandTag("UPDATE 1"), nil
+	case strings.Contains(sql, "DELETE FROM idempotency_keys"):
+		d.released = true
+		return pgconn.CommandTag("DELETE 1"), nil
+	}
+	d.t.Fatalf("unexpected statement: %s", sql)
+	return nil, nil
+}
+
+func (d *fakeDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	d.t.Fatalf("unexpected query: %s", sql)
+	return nil, nil
+}
+
+func (d *fakeDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	d.t.Fatalf("unexpected query: %s", sql)
+	return nil
+}
+
+func (d *fakeDB) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	d.t.Fatal("unexpected batch")
+	return nil
+}
+
+func newTestGuard(db *fakeDB) *Guard {
+	return &Guard{
+		Store: NewStore(db),
+		TTL:   time.Hour,
+		Scope: func(*http.Request) string { return "client" },
+	}
+}
+
+func TestHandlerStoresResponseOfCanceledRequest(t *testing.T) {
+	db := &fakeDB{t: t}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := newTestGuard(db).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+		// The client goes away after the write was made.
+		cancel()
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/posts/create", strings.NewReader(`{}`)).WithContext(ctx)
+	r.Header.Set(HeaderKey, "key")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if db.status != http.StatusCreated || string(db.body) != `{"id":1}` {
+		t.Errorf("stored %d %q, want 201 {\"id\":1}", db.status, db.body)
+	}
+	if db.released {
+		t.Error("key was released, so a retry would run the request again")
+	}
+}
+
+func TestHandlerReleasesKeyOfFailedCanceledRequest(t *testing.T) {
+	db := &fakeDB{t: t}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := newTestGuard(db).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/posts/create", strings.NewReader(`{}`)).WithContext(ctx)
+	r.Header.Set(HeaderKey, "key")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !db.released {
+		t.Error("key of a failed request was not released")
+	}
+	if db.status != 0 {
+		t.Errorf("stored status %d for a failed request", db.status)
+	}
+}
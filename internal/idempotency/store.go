@@ -0,0 +1,104 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"challenge-api/internal/database"
+)
+
+// lockTimeout is how long an unfinished request holds its key. After that a
+// retry may take the key over, e.g. when the instance handling the first
+// attempt crashed.
+const lockTimeout = time.Minute
+
+// Record is a stored idempotency key. StatusCode is zero while the first
+// request is still in flight.
+type Record struct {
+	Fingerprint string
+	StatusCode  int
+	Headers     map[string]string
+	Body        []byte
+}
+
+func (r *Record) Completed() bool {
+	return r.StatusCode != 0
+}
+
+// Store persists idempotency keys in the idempotency_keys table.
+type Store struct {
+	DB database.Querier
+}
+
+func NewStore(db database.Querier) *Store {
+	return &Store{DB: db}
+}
+
+// Acquire claims key for a new request. When the key is already held by a
+// live record, that record is returned with acquired set to false.
+func (s *Store) Acquire(ctx context.Context, key, fingerprint string, ttl time.Duration) (rec *Record, acquired bool, err error) {
+	now := time.Now()
+	query := `
+		INSERT INTO idempotency_keys AS ik (key, fingerprint, created_at, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint,
+			status_code = NULL,
+			response_headers = NULL,
+			response_body = NULL,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE ik.expires_at < $3
+			OR (ik.status_code IS NULL AND ik.created_at < $5)`
+	res, err := s.DB.Exec(ctx, query, key, fingerprint, now, now.Add(ttl), now.Add(-lockTimeout))
+	if err != nil {
+		return nil, false, err
+	}
+	if res.RowsAffected() == 1 {
+		return nil, true, nil
+	}
+
+	rec = &Record{}
+	var status *int32
+	var headers []byte
+	query = `SELECT fingerprint, status_code, response_headers, response_body FROM idempotency_keys WHERE key = $1`
+	err = s.DB.QueryRow(ctx, query, key).Scan(&rec.Fingerprint, &status, &headers, &rec.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	if status != nil {
+		rec.StatusCode = int(*status)
+	}
+	if len(headers) > 0 {
+		if err := json.Unmarshal(headers, &rec.Headers); err != nil {
+			return nil, false, err
+		}
+	}
+	return rec, false, nil
+}
+
+// Complete stores the response of the request holding key.
+func (s *Store) Complete(ctx context.Context, key string, status int, headers map[string]string, body []byte) error {
+	h, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE idempotency_keys SET status_code = $1, response_headers = $2, response_body = $3 WHERE key = $4`
+	_, err = s.DB.Exec(ctx, query, status, string(h), body, key)
+	return err
+}
+
+// Release drops the claim on key so the request can be retried.
+func (s *Store) Release(ctx context.Context, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE key = $1 AND status_code IS NULL`
+	_, err := s.DB.Exec(ctx, query, key)
+	return err
+}
+
+// Cleanup deletes expired keys.
+func (s *Store) Cleanup(ctx context.Context) error {
+	query := `DELETE FROM idempotency_keys WHERE expires_at < $1`
+	_, err := s.DB.Exec(ctx, query, time.Now())
+	return err
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a fake time source for the memory store.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newTestStore(c *clock) *MemoryStore {
+	s := NewMemoryStore()
+	s.now = c.now
+	return s
+}
+
+func TestMemoryStoreBurst(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	s := newTestStore(c)
+	limit := Limit{Rate: 1, Burst: 3}
+
+	for i := 2; i >= 0; i-- {
+		res, err := s.Take(context.Background(), "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed || res.Remaining != i {
+			t.Fatalf("take %d: got %+v, want allowed with %d remaining", 3-i, res, i)
+		}
+	}
+	res, _ := s.Take(context.Background(), "k", limit)
+	if res.Allowed {
+		t.Fatalf("take past burst: got %+v, want denied", res)
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want 1s", res.RetryAfter)
+	}
+	if res.ResetAfter != 3*time.Second {
+		t.Errorf("ResetAfter = %v, want 3s", res.ResetAfter)
+	}
+
+	// Buckets are per key.
+	if res, _ := s.Take(context.Background(), "other", limit); !res.Allowed {
+		t.Errorf("other key: got %+v, want allowed", res)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	s := newTestStore(c)
+	limit := Limit{Rate: 2, Burst: 2}
+	ctx := context.Background()
+
+	s.Take(ctx, "k", limit)
+	s.Take(ctx, "k", limit)
+	if res, _ := s.Take(ctx, "k", limit); res.Allowed {
+		t.Fatalf("empty bucket: got %+v, want denied", res)
+	}
+
+	// Half a token: still denied, and the rest arrives in 250ms.
+	c.advance(250 * time.Millisecond)
+	res, _ := s.Take(ctx, "k", limit)
+	if res.Allowed || res.RetryAfter != 250*time.Millisecond {
+		t.Fatalf("half refilled: got %+v, want denied with RetryAfter 250ms", res)
+	}
+
+	c.advance(250 * time.Millisecond)
+	if res, _ := s.Take(ctx, "k", limit); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("refilled: got %+v, want allowed with 0 remaining", res)
+	}
+
+	// A long idle period refills up to Burst, no more.
+	c.advance(time.Hour)
+	if res, _ := s.Take(ctx, "k", limit); !res.Allowed || res.Remaining != 1 {
+		t.Fatalf("after idle: got %+v, want allowed with 1 remaining", res)
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	s := newTestStore(c)
+	limit := Limit{Rate: 1, Burst: 1}
+	ctx := context.Background()
+
+	s.Take(ctx, "old", limit)
+	c.advance(time.Minute)
+	s.Take(ctx, "new", limit)
+	if err := s.Cleanup(ctx, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.buckets["old"]; ok {
+		t.Error("idle bucket was kept")
+	}
+	if _, ok := s.buckets["new"]; !ok {
+		t.Error("recent bucket was dropped")
+	}
+}
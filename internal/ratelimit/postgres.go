@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"challenge-api/internal/database"
+)
+
+// PostgresStore keeps buckets in the rate_limits table so every API instance
+// shares the same limits. Each take is a single atomic upsert.
+type PostgresStore struct {
+	DB database.Querier
+}
+
+func NewPostgresStore(db database.Querier) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	// Every SET expression sees the row as it was before the update, so the
+	// refilled amount is recomputed for each column.
+	query := `
+		INSERT INTO rate_limits AS rl (key, tokens, allowed, updated_at)
+		VALUES ($1, $2::float8 - 1, TRUE, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			allowed = LEAST($2::float8, rl.tokens + EXTRACT(EPOCH FROM NOW() - rl.updated_at) * $3::float8) >= 1,
+			tokens = LEAST($2::float8, rl.tokens + EXTRACT(EPOCH FROM NOW() - rl.updated_at) * $3::float8)
+				- CASE WHEN LEAST($2::float8, rl.tokens + EXTRACT(EPOCH FROM NOW() - rl.updated_at) * $3::float8) >= 1 THEN 1 ELSE 0 END,
+			updated_at = NOW()
+		RETURNING tokens, allowed`
+
+	var tokens float64
+	var allowed bool
+	err := s.DB.QueryRow(ctx, query, key, limit.Burst, limit.Rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+	return result(allowed, tokens, limit), nil
+}
+
+func (s *PostgresStore) Cleanup(ctx context.Context, idle time.Duration) error {
+	query := `DELETE FROM rate_limits WHERE updated_at < $1`
+	_, err := s.DB.Exec(ctx, query, time.Now().Add(-idle))
+	return err
+}
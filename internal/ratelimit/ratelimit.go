@@ -0,0 +1,143 @@
+// Package ratelimit implements token-bucket rate limiting for HTTP handlers
+// with pluggable storage for the buckets.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"challenge-api/internal/auth"
+	"challenge-api/internal/helpers"
+	"challenge-api/internal/logging"
+)
+
+// Limit describes a token bucket: it refills at Rate tokens per second up to
+// Burst tokens. A zero Rate disables limiting.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until a token is available. Zero when allowed.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+// Store keeps the buckets. Take must be atomic per key.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// Cleanup forgets buckets that haven't been used for longer than idle.
+	Cleanup(ctx context.Context, idle time.Duration) error
+}
+
+// KeyFunc identifies the client a request is accounted to.
+type KeyFunc func(r *http.Request) string
+
+// Limiter builds rate limiting middleware sharing one store and key function.
+type Limiter struct {
+	Store Store
+	Key   KeyFunc
+}
+
+// Limit returns middleware enforcing limit for the named route group. Buckets
+// are kept per group and client, so a client exhausting a strict group still
+// has its default allowance elsewhere.
+func (l *Limiter) Limit(group string, limit Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil || l.Store == nil || limit.Rate <= 0 || limit.Burst <= 0 {
+			return next
+		}
+		policy := fmt.Sprintf("%d;w=%d", limit.Burst, int(math.Ceil(float64(limit.Burst)/limit.Rate)))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := group + ":" + l.Key(r)
+			res, err := l.Store.Take(r.Context(), key, limit)
+			if err != nil {
+				// Fail open: an unavailable store must not take the API down.
+				logging.FromContext(r.Context()).Warn("Rate limit store error", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Policy", policy)
+			h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				helpers.ErrorJSON(w, errors.New("rate limit exceeded"), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ClientKey identifies a request by the user auth.Authenticate resolved for
+// it, and otherwise by the client IP. Credentials that weren't verified are
+// ignored, since a client could send a new one with every request to get a
+// fresh bucket. When trustProxy is set, the IP is taken from X-Forwarded-For /
+// X-Real-IP instead of the connection.
+func ClientKey(trustProxy bool) KeyFunc {
+	return func(r *http.Request) string {
+		if id, ok := auth.UserID(r.Context()); ok {
+			return "user:" + id
+		}
+		return "ip:" + clientIP(r, trustProxy)
+	}
+}
+
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			return strings.TrimSpace(first)
+		}
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// refill returns the tokens in a bucket that held tokens elapsed ago.
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	return math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+}
+
+// result builds the Result for a bucket left with tokens after a take.
+func result(allowed bool, tokens float64, limit Limit) Result {
+	res := Result{
+		Allowed:    allowed,
+		Remaining:  int(math.Max(0, math.Floor(tokens))),
+		ResetAfter: seconds((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitHeaders(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	l := &Limiter{Store: newTestStore(c), Key: func(*http.Request) string { return "client" }}
+	h := l.Limit("default", Limit{Rate: 0.5, Burst: 2})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{http.StatusNoContent, "1", "2", ""},
+		{http.StatusNoContent, "0", "4", ""},
+		{http.StatusTooManyRequests, "0", "4", "2"},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != tt.status {
+			t.Errorf("request %d: status %d, want %d", i, w.Code, tt.status)
+		}
+		want := map[string]string{
+			"RateLimit-Policy":    "2;w=4",
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": tt.remaining,
+			"RateLimit-Reset":     tt.reset,
+			"Retry-After":         tt.retryAfter,
+		}
+		for name, v := range want {
+			if got := w.Header().Get(name); got != v {
+				t.Errorf("request %d: %s = %q, want %q", i, name, got, v)
+			}
+		}
+	}
+}
+
+func TestClientKeyIgnoresUnverifiedCredentials(t *testing.T) {
+	key := ClientKey(false)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("Authorization", "Bearer made-up")
+	if got := key(r); got != "ip:192.0.2.1" {
+		t.Errorf("key = %q, want ip:192.0.2.1", got)
+	}
+
+	r.Header.Set("X-Forwarded-For", "198.51.100.7, 10.0.0.1")
+	if got := ClientKey(true)(r); got != "ip:198.51.100.7" {
+		t.Errorf("key behind proxy = %q, want ip:198.51.100.7", got)
+	}
+}
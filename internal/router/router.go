@@ -1,8 +1,17 @@
 package router
 
 import (
+	"challenge-api/internal/auth"
+	"challenge-api/internal/consistency"
 	"challenge-api/internal/controllers"
+	"challenge-api/internal/idempotency"
+	"challenge-api/internal/logging"
+	"challenge-api/internal/metrics"
+	"challenge-api/internal/ratelimit"
+	"challenge-api/internal/stream"
+	"challenge-api/internal/tracing"
 	"net/http"
+	"time"
 
 	_ "challenge-api/docs"
 
@@ -12,19 +21,55 @@ import (
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
+// Config holds the dependencies and limits the routes are built with.
+type Config struct {
+	// RateLimiter is shared by every route group. Nil disables rate limiting.
+	RateLimiter *ratelimit.Limiter
+	// DefaultRateLimit applies to every API route.
+	DefaultRateLimit ratelimit.Limit
+	// StrictRateLimit additionally applies to abuse-prone routes such as
+	// account creation.
+	StrictRateLimit ratelimit.Limit
+	// Idempotency replays responses of create routes retried with the same
+	// Idempotency-Key. Nil disables it.
+	Idempotency *idempotency.Guard
+	// AdminToken is the bearer token required by the admin routes. Empty
+	// disables them.
+	AdminToken string
+	// Consistency sends the reads of clients that just wrote to the primary
+	// database. Nil when there are no replicas.
+	Consistency *consistency.Tracker
+	// SessionTTL is how long the sessions opened by logging in last.
+	SessionTTL time.Duration
+	// Stream delivers the events of the event stream route.
+	Stream *stream.Hub
+	// StreamHeartbeat is the time between heartbeats on an idle stream.
+	StreamHeartbeat time.Duration
+}
+
 // @title Sensedia Challenge API
 // @version 1
 // @description This is the API for the Sensedia Challenge
 // @BasePath /api/v1
-func Routes() http.Handler {
+// @securityDefinitions.apikey AdminToken
+// @in header
+// @name Authorization
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Session token from /auth/login, as "Bearer <token>"
+func Routes(cfg Config) http.Handler {
 	router := chi.NewRouter()
+	router.Use(tracing.Middleware)
+	router.Use(middleware.RequestID)
+	router.Use(logging.Middleware)
+	router.Use(metrics.Middleware)
 	router.Use(middleware.Recoverer)
-	router.Use(middleware.Logger)
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Idempotency-Key", "If-Match", "If-None-Match", "traceparent", "tracestate", "X-Request-Id"},
+		ExposedHeaders:   []string{"Link", "ETag", "X-Request-Id", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -32,44 +77,122 @@ func Routes() http.Handler {
 		w.Write([]byte("API Root"))
 	})
 
+	// Health probes
+	router.Get("/healthz", controllers.Healthz)
+	router.Get("/readyz", controllers.Readyz)
+	router.Handle("/metrics", metrics.Handler())
+
+	// Clients are rate limited, and tracked for read-your-writes, by the
+	// user their credentials resolve to, so Authenticate comes first.
+	api := router.With(auth.Authenticate, cfg.RateLimiter.Limit("default", cfg.DefaultRateLimit), cfg.Consistency.Handler)
+	strict := cfg.RateLimiter.Limit("strict", cfg.StrictRateLimit)
+	idempotent := cfg.Idempotency.Handler
+
+	// Auth routes
+	api.Route("/api/v1/auth", func(r chi.Router) {
+		r.With(strict).Post("/login", controllers.Login(cfg.SessionTTL))
+		r.With(auth.RequireUser).Post("/logout", controllers.Logout)
+	})
+
 	// User routes
-	router.Route("/api/v1/users", func(r chi.Router) {
+	api.Route("/api/v1/users", func(r chi.Router) {
 		r.Get("/", controllers.GetAllUsers)
-		r.Post("/create", controllers.CreateUser)
+		r.With(strict, idempotent).Post("/create", controllers.CreateUser)
 		r.Get("/{username}", controllers.GetUserByUsername) // Explicit route for username
 		r.Route("/{id:[a-fA-F0-9\\-]+}", func(r chi.Router) { // UUID regex
 			r.Get("/albums", controllers.GetAlbumsByUserID)
 			r.Get("/posts", controllers.GetPostsByUserID)
+			r.Get("/followers", controllers.GetFollowers)
+			r.Get("/following", controllers.GetFollowing)
+			r.With(auth.RequireUser).Post("/follow", controllers.FollowUser)
+			r.With(auth.RequireUser).Delete("/follow", controllers.UnfollowUser)
 			r.Delete("/albums/{album_id}", controllers.RemoveAlbumFromUser)
 			r.Get("/", controllers.GetUserByID)
 			r.Put("/", controllers.UpdateUser)
+			r.Patch("/", controllers.PatchUser)
 			r.Delete("/", controllers.DeleteUser)
 		})
 	})
 
+	// Feed routes
+	api.With(auth.RequireUser).Get("/api/v1/feed", controllers.GetFeed)
+
+	// Event stream
+	api.With(auth.RequireUser).Get("/api/v1/stream", controllers.Stream(cfg.Stream, cfg.StreamHeartbeat))
+
+	// Conversation routes
+	api.With(auth.RequireUser).Route("/api/v1/conversations", func(r chi.Router) {
+		r.Get("/", controllers.GetConversations)
+		r.Post("/", controllers.CreateConversation)
+		r.Get("/ws", controllers.Chat(cfg.Stream))
+		r.Route("/{id:[a-fA-F0-9\\-]+}", func(r chi.Router) { // UUID regex
+			r.Get("/", controllers.GetConversationByID)
+			r.Get("/messages", controllers.GetMessages)
+			r.With(idempotent).Post("/messages", controllers.SendMessage)
+			r.Post("/read", controllers.MarkConversationRead)
+		})
+	})
+
+	// Notification routes
+	api.With(auth.RequireUser).Route("/api/v1/notifications", func(r chi.Router) {
+		r.Get("/", controllers.GetNotifications)
+		r.Get("/unread_count", controllers.GetUnreadCount)
+		r.Post("/read", controllers.MarkAllNotificationsRead)
+		r.Post("/{id:[a-fA-F0-9\\-]+}/read", controllers.MarkNotificationRead)
+	})
+
+	// Hashtag routes
+	api.Route("/api/v1/hashtags", func(r chi.Router) {
+		r.Get("/trending", controllers.GetTrendingHashtags)
+		r.Get("/{tag}/posts", controllers.GetPostsByHashtag)
+	})
+
 	// Album routes
-	router.Route("/api/v1/albums", func(r chi.Router) {
+	api.Route("/api/v1/albums", func(r chi.Router) {
 		r.Get("/", controllers.GetAllAlbums)
-		r.Post("/create", controllers.CreateAlbum)
-		r.Post("/save", controllers.AddAlbumToUser)
+		r.With(idempotent).Post("/create", controllers.CreateAlbum)
+		r.With(idempotent).Post("/save", controllers.AddAlbumToUser)
 		r.Route("/{id:[a-fA-F0-9\\-]+}", func(r chi.Router) { // UUID regex
 			r.Get("/", controllers.GetAlbumByID)
 			r.Put("/", controllers.UpdateAlbum)
+			r.Patch("/", controllers.PatchAlbum)
 			r.Delete("/", controllers.DeleteAlbum)
 		})
 	})
 
 	// Post routes
-	router.Route("/api/v1/posts", func(r chi.Router) {
+	api.Route("/api/v1/posts", func(r chi.Router) {
 		r.Get("/", controllers.GetAllPosts)
-		r.Post("/create", controllers.CreatePost)
+		r.With(idempotent).Post("/create", controllers.CreatePost)
 		r.Route("/{id:[a-fA-F0-9\\-]+}", func(r chi.Router) { // UUID regex
 			r.Get("/", controllers.GetPostByID)
 			r.Put("/", controllers.UpdatePost)
+			r.Patch("/", controllers.PatchPost)
 			r.Delete("/", controllers.DeletePost)
+			r.Get("/revisions", controllers.GetPostRevisions)
+			r.With(auth.RequireUser).Post("/revisions/{revision:[0-9]+}/revert", controllers.RevertPost)
+			r.Get("/comments", controllers.GetComments)
+			r.With(auth.RequireUser, idempotent).Post("/comments", controllers.CreateComment)
+			r.With(auth.RequireUser).Put("/reactions/{type}", controllers.ReactToPost)
+			r.With(auth.RequireUser).Delete("/reactions/{type}", controllers.RemoveReaction)
 		})
 	})
 
+	// Comment routes
+	api.Route("/api/v1/comments/{id:[a-fA-F0-9\\-]+}", func(r chi.Router) { // UUID regex
+		r.Get("/", controllers.GetCommentByID)
+		r.With(auth.RequireUser).Put("/", controllers.UpdateComment)
+		r.With(auth.RequireUser).Delete("/", controllers.DeleteComment)
+	})
+
+	// Admin routes
+	api.Route("/api/v1/admin", func(r chi.Router) {
+		r.Use(auth.RequireAdmin(cfg.AdminToken))
+		r.Post("/users/{id:[a-fA-F0-9\\-]+}/restore", controllers.RestoreUser)
+		r.Post("/posts/{id:[a-fA-F0-9\\-]+}/restore", controllers.RestorePost)
+		r.Post("/albums/{id:[a-fA-F0-9\\-]+}/restore", controllers.RestoreAlbum)
+	})
+
 	// Swagger route
 	router.Route("/swagger", func(r chi.Router) {
 		r.Get("/*", httpSwagger.WrapHandler)
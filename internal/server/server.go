@@ -1,37 +1,145 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"challenge-api/internal/controllers"
+	"challenge-api/internal/database"
 	"challenge-api/internal/router"
 	"challenge-api/internal/services"
-
-	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Port string
+	Port              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	// DrainDelay is how long the readiness probe fails before the server
+	// stops accepting connections, so load balancers can react first.
+	DrainDelay time.Duration
+	Router     router.Config
 }
 
 type Application struct {
 	Config Config
 	Models services.Models
+	DB     *database.DB
+
+	wg     sync.WaitGroup
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (app *Application) init() {
+	app.once.Do(func() {
+		app.ctx, app.cancel = context.WithCancel(context.Background())
+	})
+}
+
+// Background runs fn in a goroutine tracked by the application. The context
+// passed to fn is canceled when the server starts shutting down, and Serve
+// waits for fn to return before closing the database.
+func (app *Application) Background(fn func(ctx context.Context)) {
+	app.init()
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				slog.Error("Background worker panic", "error", err)
+			}
+		}()
+		fn(app.ctx)
+	}()
 }
 
 func (app *Application) Serve() error {
-	err := godotenv.Load()
+	app.init()
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%s", app.Config.Port),
+		Handler:           router.Routes(app.Config.Router),
+		ReadTimeout:       app.Config.ReadTimeout,
+		ReadHeaderTimeout: app.Config.ReadHeaderTimeout,
+		WriteTimeout:      app.Config.WriteTimeout,
+		IdleTimeout:       app.Config.IdleTimeout,
+	}
+	// Streams never go idle on their own; end them so Shutdown can finish.
+	srv.RegisterOnShutdown(app.Config.Router.Stream.Close)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+		signal.Stop(quit)
+		slog.Info("Shutting down server", "signal", s.String())
+
+		controllers.StartDraining()
+		time.Sleep(app.Config.DrainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.Config.ShutdownTimeout)
+		defer cancel()
+		shutdownErr <- app.shutdown(ctx, srv)
+	}()
+
+	slog.Info("API is running", "port", app.Config.Port)
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		// E.g. the port is taken: nothing was served, but the workers and
+		// the pool still have to be stopped.
+		ctx, cancel := context.WithTimeout(context.Background(), app.Config.ShutdownTimeout)
+		defer cancel()
+		return errors.Join(err, app.stop(ctx))
+	}
+	err = <-shutdownErr
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return err
 	}
-	port := os.Getenv("PORT")
-	fmt.Println("API is running on port ",  port)
-	srv := &http.Server{
-		Addr: fmt.Sprintf(":%s", port),
-		Handler: router.Routes(),
-		
+	slog.Info("Server stopped")
+	return nil
+}
+
+// shutdown stops accepting connections and drains in-flight requests and
+// chat connections, then stops the background workers and closes the
+// database pool. Every step shares the deadline carried by ctx.
+func (app *Application) shutdown(ctx context.Context, srv *http.Server) error {
+	err := srv.Shutdown(ctx)
+	err = errors.Join(err, controllers.CloseChats(ctx))
+	return errors.Join(err, app.stop(ctx))
+}
+
+// stop stops the background workers, waiting for them until ctx is done,
+// then closes the database pool.
+func (app *Application) stop(ctx context.Context) error {
+	var err error
+	app.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = errors.New("background workers did not finish before the shutdown deadline")
+	}
+
+	if app.DB != nil {
+		app.DB.Replicas.Close()
+		app.DB.Pool.Close()
 	}
-	return srv.ListenAndServe()
+	return err
 }
@@ -3,6 +3,8 @@ package services
 import (
 	"context"
 	"time"
+
+	"github.com/jackc/pgx/v4"
 )
 
 type Album struct {
@@ -18,6 +20,13 @@ type AlbumPayload struct {
 	Description string `json:"description"`
 }
 
+// AlbumPatch is a JSON merge patch for an album: omitted fields are left
+// untouched.
+type AlbumPatch struct {
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
 type AlbumsList struct {
 	Albums []Album `json:"albums"`
 }
@@ -36,11 +45,11 @@ type UserAlbumsList struct {
 	UserAlbums []UserAlbum `json:"user_albums"`
 }
 
-func (a *Album) GetAllAlbums() ([]*Album, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (a *Album) GetAllAlbums(ctx context.Context) ([]*Album, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
 	defer cancel()
-	query := `SELECT id, title, description, created_at, updated_at FROM albums`
-	rows, err := db.QueryContext(ctx, query)
+	query := `SELECT id, title, description, created_at, updated_at FROM albums WHERE deleted_at IS NULL`
+	rows, err := db.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -62,11 +71,11 @@ func (a *Album) GetAllAlbums() ([]*Album, error) {
 	return albums, nil
 }
 
-func (a *Album) GetAlbumByID(id string) (*Album, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (a *Album) GetAlbumByID(ctx context.Context, id string) (*Album, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
 	defer cancel()
-	query := `SELECT id, title, description, created_at, updated_at FROM albums WHERE id = $1`
-	row := db.QueryRowContext(ctx, query, id)
+	query := `SELECT id, title, description, created_at, updated_at FROM albums WHERE id = $1 AND deleted_at IS NULL`
+	row := db.QueryRow(ctx, query, id)
 	err := row.Scan(
 		&a.ID,
 		&a.Title,
@@ -80,52 +89,83 @@ func (a *Album) GetAlbumByID(id string) (*Album, error) {
 	return a, nil
 }
 
-func (a *Album) CreateAlbum(album Album) (*Album, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (a *Album) CreateAlbum(ctx context.Context, album Album) (*Album, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 	query := `INSERT INTO albums (title, description, created_at, updated_at) VALUES ($1, $2, $3, $4) RETURNING id, title, description`
-	err := db.QueryRowContext(ctx, query, album.Title, album.Description, time.Now(), time.Now()).Scan(&album.ID, &album.Title, &album.Description)
+	err := db.QueryRow(ctx, query, album.Title, album.Description, time.Now(), time.Now()).Scan(&album.ID, &album.Title, &album.Description)
+	if err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// UpdateAlbum overwrites the album if it is still at version (its last
+// update time). A zero version updates unconditionally.
+func (a *Album) UpdateAlbum(ctx context.Context, id string, album Album, version time.Time) (*Album, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `UPDATE albums SET title = $1, description = $2, updated_at = $3 WHERE id = $4 AND deleted_at IS NULL AND ($5::timestamptz IS NULL OR updated_at = $5) RETURNING id, title, description, created_at, updated_at`
+	row := db.QueryRow(ctx, query, album.Title, album.Description, time.Now(), id, versionArg(version))
+	err := row.Scan(&album.ID, &album.Title, &album.Description, &album.CreatedAt, &album.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, conditionFailed(ctx, "albums", id)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &album, nil
 }
 
-func (a *Album) UpdateAlbum(id string, album Album) (*Album, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// PatchAlbum applies patch to the album if it is still at version and
+// returns the stored album. A zero version patches unconditionally.
+func (a *Album) PatchAlbum(ctx context.Context, id string, patch AlbumPatch, version time.Time) (*Album, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
-	query := `UPDATE albums SET title = $1, description = $2, updated_at = $3 WHERE id = $4 RETURNING id, title, description, updated_at`
-	row := db.QueryRowContext(ctx, query, album.Title, album.Description, time.Now(), id)
-	err := row.Scan(&album.ID, &album.Title, &album.Description, &album.UpdatedAt)
+	var set patchSet
+	set.set("title", patch.Title)
+	set.set("description", patch.Description)
+	query, args := set.query("albums", id, version, "id, title, description, created_at, updated_at")
+	var album Album
+	err := db.QueryRow(ctx, query, args...).Scan(&album.ID, &album.Title, &album.Description, &album.CreatedAt, &album.UpdatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, conditionFailed(ctx, "albums", id)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &album, nil
 }
 
-func (a *Album) DeleteAlbum(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// DeleteAlbum soft-deletes the album if it is still at version. A zero
+// version deletes unconditionally.
+func (a *Album) DeleteAlbum(ctx context.Context, id string, version time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
-	query := `DELETE FROM albums WHERE id = $1`
-	_, err := db.ExecContext(ctx, query, id)
+	query := `UPDATE albums SET deleted_at = $3 WHERE id = $1 AND deleted_at IS NULL AND ($2::timestamptz IS NULL OR updated_at = $2)`
+	res, err := db.Exec(ctx, query, id, versionArg(version), time.Now())
 	if err != nil {
 		return err
 	}
+	if res.RowsAffected() == 0 {
+		return conditionFailed(ctx, "albums", id)
+	}
 	return nil
 }
 
-func (a *Album) GetUserAlbums(userID string) ([]*Album, error) {
-    ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (a *Album) GetUserAlbums(ctx context.Context, userID string) ([]*Album, error) {
+    ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
     defer cancel()
 
     query := `
         SELECT a.id, a.title, a.description, a.created_at, a.updated_at 
         FROM albums a
         JOIN user_albums ua ON a.id = ua.album_id
-        WHERE ua.user_id = $1
+        JOIN users u ON u.id = ua.user_id
+        WHERE ua.user_id = $1 AND a.deleted_at IS NULL AND u.deleted_at IS NULL
     `
 
-    rows, err := db.QueryContext(ctx, query, userID)
+    rows, err := db.Query(ctx, query, userID)
     if err != nil {
         return nil, err
     }
@@ -154,30 +194,64 @@ func (a *Album) GetUserAlbums(userID string) ([]*Album, error) {
     return albums, nil
 }
 
-func (a *Album) AddAlbumToUser(userAlbum UserAlbum) (*UserAlbum, error) {
-    ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// AddAlbumToUser saves an album for a user. When actorID is someone else,
+// the album is being shared with the user, who is notified.
+func (a *Album) AddAlbumToUser(ctx context.Context, userAlbum UserAlbum, actorID string) (*UserAlbum, error) {
+    ctx, cancel := context.WithTimeout(ctx, dbTimeout)
     defer cancel()
 
-    query := `INSERT INTO user_albums (user_id, album_id, added_at) VALUES ($1, $2, $3)`
+    err := WithTx(ctx, func(ctx context.Context) error {
+        query := `
+            INSERT INTO user_albums (user_id, album_id, added_at)
+            SELECT $1, $2, $3
+            WHERE EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)
+              AND EXISTS (SELECT 1 FROM albums WHERE id = $2 AND deleted_at IS NULL)
+        `
 
-    _, err := db.ExecContext(ctx, query, userAlbum.UserID, userAlbum.AlbumID, time.Now())
+        res, err := db.Exec(ctx, query, userAlbum.UserID, userAlbum.AlbumID, time.Now())
+        if err != nil {
+            return err
+        }
+        if res.RowsAffected() == 0 {
+            return ErrNotFound
+        }
+        if actorID == "" {
+            return nil
+        }
+        return notify(ctx, []string{userAlbum.UserID}, notice{Type: NotificationAlbumShared, ActorID: actorID, AlbumID: &userAlbum.AlbumID})
+    })
     if err != nil {
-        return nil,err
+        return nil, err
     }
 
     return &userAlbum, nil
 }
 
-func (a *Album) RemoveAlbumFromUser(userID string, albumID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (a *Album) RemoveAlbumFromUser(ctx context.Context, userID string, albumID string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := `DELETE FROM user_albums WHERE user_id = $1 AND album_id = $2`
 
-	_, err := db.ExecContext(ctx, query, userID, albumID)
+	_, err := db.Exec(ctx, query, userID, albumID)
 	if err != nil {
 		return err
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// RestoreAlbum undoes the soft deletion of an album.
+func (a *Album) RestoreAlbum(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `UPDATE albums SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`
+	res, err := db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
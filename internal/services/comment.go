@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Comment is a response to a post, or to another comment of the same post
+// when ParentID is set. A deleted comment that still has replies is kept in
+// its thread with its content blanked out.
+type Comment struct {
+	ID         string    `json:"id"`
+	PostID     string    `json:"post_id"`
+	UserID     string    `json:"user_id"`
+	ParentID   *string   `json:"parent_id"`
+	Content    string    `json:"content"`
+	ReplyCount int       `json:"reply_count"`
+	Deleted    bool      `json:"deleted,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type CommentPayload struct {
+	Content  string  `json:"content"`
+	ParentID *string `json:"parent_id,omitempty"`
+}
+
+type CommentsList struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+const commentColumns = `c.id, c.post_id, c.user_id, c.parent_id,
+	CASE WHEN c.deleted_at IS NULL THEN c.content ELSE '' END,
+	(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id AND r.deleted_at IS NULL),
+	c.deleted_at IS NOT NULL, c.created_at, c.updated_at`
+
+func scanComment(row pgx.Row, c *Comment) error {
+	return row.Scan(&c.ID, &c.PostID, &c.UserID, &c.ParentID, &c.Content, &c.ReplyCount, &c.Deleted, &c.CreatedAt, &c.UpdatedAt)
+}
+
+// GetComments lists, oldest first, the comments of a post that reply to
+// parentID, or the top-level ones when parentID is nil.
+func (c *Comment) GetComments(ctx context.Context, postID string, parentID *string, page Page) (*CommentsList, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+
+	afterTime, afterID, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL)`
+	if err := db.QueryRow(ctx, query, postID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	query = `
+		SELECT ` + commentColumns + `
+		FROM comments c
+		WHERE c.post_id = $1
+			AND c.parent_id IS NOT DISTINCT FROM $2
+			AND (c.deleted_at IS NULL OR EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id AND r.deleted_at IS NULL))
+			AND ($3::timestamptz IS NULL OR (c.created_at, c.id) > ($3, $4::uuid))
+		ORDER BY c.created_at, c.id
+		LIMIT $5`
+	rows, err := db.Query(ctx, query, postID, parentID, versionArg(afterTime), nullString(afterID), page.size())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := CommentsList{Comments: []Comment{}}
+	for rows.Next() {
+		var comment Comment
+		if err := scanComment(rows, &comment); err != nil {
+			return nil, err
+		}
+		list.Comments = append(list.Comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if n := len(list.Comments); n == page.size() {
+		last := list.Comments[n-1]
+		list.NextCursor = cursorAt(last.CreatedAt, last.ID)
+	}
+	return &list, nil
+}
+
+// GetCommentByID returns a live comment of a live post.
+func (c *Comment) GetCommentByID(ctx context.Context, id string) (*Comment, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+	query := `
+		SELECT ` + commentColumns + `
+		FROM comments c
+		JOIN posts p ON p.id = c.post_id AND p.deleted_at IS NULL
+		WHERE c.id = $1 AND c.deleted_at IS NULL`
+	var comment Comment
+	err := scanComment(db.QueryRow(ctx, query, id), &comment)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// CreateComment adds a comment by userID to the post, and notifies the
+// author of the post and, for a reply, of the parent comment. A reply must
+// name a live comment of the same post as its parent.
+func (c *Comment) CreateComment(ctx context.Context, postID string, userID string, payload CommentPayload) (*Comment, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	var comment Comment
+	err := WithTx(ctx, func(ctx context.Context) error {
+		query := `
+			INSERT INTO comments (post_id, user_id, parent_id, content, created_at, updated_at)
+			SELECT $1, $2, $3, $4, $5, $5
+			WHERE EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL)
+				AND ($3::uuid IS NULL OR EXISTS (SELECT 1 FROM comments WHERE id = $3 AND post_id = $1 AND deleted_at IS NULL))
+			RETURNING id, post_id, user_id, parent_id, content, created_at, updated_at`
+		err := db.QueryRow(ctx, query, postID, userID, payload.ParentID, payload.Content, time.Now()).Scan(
+			&comment.ID, &comment.PostID, &comment.UserID, &comment.ParentID, &comment.Content, &comment.CreatedAt, &comment.UpdatedAt)
+		if err == pgx.ErrNoRows {
+			if payload.ParentID == nil {
+				return ErrNotFound
+			}
+			// Either the post or the parent is missing; tell which.
+			if err := conditionFailed(ctx, "posts", postID); err != ErrPreconditionFailed {
+				return err
+			}
+			return &ConstraintError{Kind: ErrInvalidReference, Constraint: "comments_parent_id_fkey", Field: "parent_id", Message: "parent comment does not exist on this post"}
+		}
+		if err != nil {
+			return err
+		}
+
+		query = `
+			SELECT user_id FROM posts WHERE id = $1
+			UNION
+			SELECT user_id FROM comments WHERE id = $2`
+		rows, err := db.Query(ctx, query, postID, payload.ParentID)
+		if err != nil {
+			return err
+		}
+		var recipients []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			recipients = append(recipients, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return notify(ctx, recipients, notice{Type: NotificationComment, ActorID: userID, PostID: &comment.PostID, CommentID: &comment.ID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// UpdateComment replaces the content of a comment written by userID if it is
+// still at version. Comments of a deleted post can't be edited. A zero
+// version updates unconditionally.
+func (c *Comment) UpdateComment(ctx context.Context, id string, userID string, payload CommentPayload, version time.Time) (*Comment, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `
+		UPDATE comments c SET content = $1, updated_at = $2
+		FROM posts p
+		WHERE p.id = c.post_id AND p.deleted_at IS NULL
+			AND c.id = $3 AND c.user_id = $4 AND c.deleted_at IS NULL AND ($5::timestamptz IS NULL OR c.updated_at = $5)
+		RETURNING ` + commentColumns
+	var comment Comment
+	err := scanComment(db.QueryRow(ctx, query, payload.Content, time.Now(), id, userID, versionArg(version)), &comment)
+	if err == pgx.ErrNoRows {
+		return nil, commentConditionFailed(ctx, id, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// DeleteComment soft-deletes a comment written by userID if it is still at
+// version. Its replies stay in the thread. Comments of a deleted post are
+// left as they are. A zero version deletes unconditionally.
+func (c *Comment) DeleteComment(ctx context.Context, id string, userID string, version time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `
+		UPDATE comments c SET deleted_at = $4
+		FROM posts p
+		WHERE p.id = c.post_id AND p.deleted_at IS NULL
+			AND c.id = $1 AND c.user_id = $2 AND c.deleted_at IS NULL AND ($3::timestamptz IS NULL OR c.updated_at = $3)`
+	res, err := db.Exec(ctx, query, id, userID, versionArg(version), time.Now())
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return commentConditionFailed(ctx, id, userID)
+	}
+	return nil
+}
+
+// commentConditionFailed explains why a write by userID on a comment matched
+// no rows: it doesn't exist or its post was deleted, someone else wrote it,
+// or its version moved on.
+func commentConditionFailed(ctx context.Context, id string, userID string) error {
+	var authorID string
+	query := `
+		SELECT c.user_id FROM comments c
+		JOIN posts p ON p.id = c.post_id AND p.deleted_at IS NULL
+		WHERE c.id = $1 AND c.deleted_at IS NULL`
+	err := db.QueryRow(ctx, query, id).Scan(&authorID)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if authorID != userID {
+		return ErrForbidden
+	}
+	return ErrPreconditionFailed
+}
+
+// nullString turns an empty string into NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,444 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// maxConversationMembers caps group conversations, the creator included.
+const maxConversationMembers = 20
+
+// Conversation is a private thread of messages between its members: two of
+// them for a direct (1:1) conversation, up to maxConversationMembers for a
+// group. Members only see conversations they belong to.
+type Conversation struct {
+	ID          string               `json:"id"`
+	Title       *string              `json:"title"`
+	Direct      bool                 `json:"direct"`
+	Members     []ConversationMember `json:"members"`
+	LastMessage *Message             `json:"last_message"`
+	// UnreadCount counts the messages from others the viewer hasn't read.
+	UnreadCount int       `json:"unread_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ConversationMember tells, along with who a member is, how far they have
+// read, which is what read receipts show.
+type ConversationMember struct {
+	UserID            string     `json:"user_id"`
+	UserName          string     `json:"user_name"`
+	LastReadMessageID *string    `json:"last_read_message_id"`
+	LastReadAt        *time.Time `json:"last_read_at"`
+}
+
+type Message struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	UserID         string    `json:"user_id"`
+	Content        string    `json:"content"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ConversationPayload starts a conversation with MemberIDs. A single member
+// without a title makes a direct conversation.
+type ConversationPayload struct {
+	MemberIDs []string `json:"member_ids"`
+	Title     *string  `json:"title,omitempty"`
+}
+
+type MessagePayload struct {
+	Content string `json:"content"`
+}
+
+type ConversationsList struct {
+	Conversations []*Conversation `json:"conversations"`
+	NextCursor    string          `json:"next_cursor,omitempty"`
+}
+
+type MessagesList struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// ReadReceipt tells the members of a conversation how far one of them read.
+type ReadReceipt struct {
+	ConversationID string     `json:"conversation_id"`
+	UserID         string     `json:"user_id"`
+	MessageID      *string    `json:"message_id"`
+	ReadAt         *time.Time `json:"read_at"`
+}
+
+// Typing tells the members of a conversation that one of them is typing.
+type Typing struct {
+	ConversationID string `json:"conversation_id"`
+	UserID         string `json:"user_id"`
+}
+
+const conversationColumns = `c.id, c.title, c.direct_key IS NOT NULL, c.created_at, c.updated_at,
+	(SELECT COUNT(*) FROM messages m
+		WHERE m.conversation_id = c.id AND m.user_id <> me.user_id
+			AND (me.last_read_at IS NULL OR m.created_at > me.last_read_at))`
+
+func scanConversation(row pgx.Row, c *Conversation) error {
+	return row.Scan(&c.ID, &c.Title, &c.Direct, &c.CreatedAt, &c.UpdatedAt, &c.UnreadCount)
+}
+
+// GetConversations lists the conversations of userID, most recently active
+// first.
+func (c *Conversation) GetConversations(ctx context.Context, userID string, page Page) (*ConversationsList, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+
+	afterTime, afterID, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + conversationColumns + `
+		FROM conversation_members me
+		JOIN conversations c ON c.id = me.conversation_id
+		WHERE me.user_id = $1
+			AND ($2::timestamptz IS NULL OR (c.updated_at, c.id) < ($2, $3::uuid))
+		ORDER BY c.updated_at DESC, c.id DESC
+		LIMIT $4`
+	rows, err := db.Query(ctx, query, userID, versionArg(afterTime), nullString(afterID), page.size())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := ConversationsList{Conversations: []*Conversation{}}
+	for rows.Next() {
+		var conversation Conversation
+		if err := scanConversation(rows, &conversation); err != nil {
+			return nil, err
+		}
+		list.Conversations = append(list.Conversations, &conversation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := attachConversationDetails(ctx, list.Conversations); err != nil {
+		return nil, err
+	}
+	if n := len(list.Conversations); n == page.size() {
+		last := list.Conversations[n-1]
+		list.NextCursor = cursorAt(last.UpdatedAt, last.ID)
+	}
+	return &list, nil
+}
+
+// GetConversationByID returns a conversation of userID.
+func (c *Conversation) GetConversationByID(ctx context.Context, id string, userID string) (*Conversation, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+	return getConversation(ctx, id, userID)
+}
+
+func getConversation(ctx context.Context, id string, userID string) (*Conversation, error) {
+	query := `
+		SELECT ` + conversationColumns + `
+		FROM conversation_members me
+		JOIN conversations c ON c.id = me.conversation_id
+		WHERE me.conversation_id = $1 AND me.user_id = $2`
+	var conversation Conversation
+	err := scanConversation(db.QueryRow(ctx, query, id, userID), &conversation)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := attachConversationDetails(ctx, []*Conversation{&conversation}); err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// attachConversationDetails fills in the members and last message of
+// conversations, with one query for each.
+func attachConversationDetails(ctx context.Context, conversations []*Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+	ids := make([]string, len(conversations))
+	byID := make(map[string]*Conversation, len(conversations))
+	for i, c := range conversations {
+		ids[i] = c.ID
+		byID[c.ID] = c
+		c.Members = []ConversationMember{}
+	}
+
+	query := `
+		SELECT cm.conversation_id, cm.user_id, u.user_name, cm.last_read_message_id, cm.last_read_at
+		FROM conversation_members cm
+		JOIN users u ON u.id = cm.user_id AND u.deleted_at IS NULL
+		WHERE cm.conversation_id = ANY($1::uuid[])
+		ORDER BY cm.conversation_id, cm.joined_at, cm.user_id`
+	rows, err := db.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var conversationID string
+		var m ConversationMember
+		if err := rows.Scan(&conversationID, &m.UserID, &m.UserName, &m.LastReadMessageID, &m.LastReadAt); err != nil {
+			return err
+		}
+		c := byID[conversationID]
+		c.Members = append(c.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	query = `
+		SELECT DISTINCT ON (m.conversation_id) m.id, m.conversation_id, m.user_id, m.content, m.created_at
+		FROM messages m
+		WHERE m.conversation_id = ANY($1::uuid[])
+		ORDER BY m.conversation_id, m.created_at DESC, m.id DESC`
+	rows, err = db.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m Message
+		if err := scanMessage(rows, &m); err != nil {
+			return err
+		}
+		byID[m.ConversationID].LastMessage = &m
+	}
+	return rows.Err()
+}
+
+// CreateConversation starts a conversation between userID and the members of
+// the payload. Starting a direct conversation that already exists returns the
+// existing one, and created is false.
+func (c *Conversation) CreateConversation(ctx context.Context, userID string, payload ConversationPayload) (conversation *Conversation, created bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	members := []string{userID}
+	seen := map[string]bool{userID: true}
+	for _, id := range payload.MemberIDs {
+		if !seen[id] {
+			seen[id] = true
+			members = append(members, id)
+		}
+	}
+	if len(members) < 2 {
+		return nil, false, &ConstraintError{Kind: ErrInvalid, Field: "member_ids", Message: "a conversation needs at least one other member"}
+	}
+	if len(members) > maxConversationMembers {
+		return nil, false, &ConstraintError{Kind: ErrInvalid, Field: "member_ids", Message: fmt.Sprintf("a conversation has at most %d members", maxConversationMembers)}
+	}
+	var directKey *string
+	if len(members) == 2 && payload.Title == nil {
+		pair := []string{members[0], members[1]}
+		sort.Strings(pair)
+		key := strings.Join(pair, ":")
+		directKey = &key
+	}
+
+	var id string
+	err = WithTx(ctx, func(ctx context.Context) error {
+		var live int
+		query := `SELECT COUNT(*) FROM users WHERE id = ANY($1::uuid[]) AND deleted_at IS NULL`
+		if err := db.QueryRow(ctx, query, members).Scan(&live); err != nil {
+			return err
+		}
+		if live != len(members) {
+			return &ConstraintError{Kind: ErrInvalidReference, Field: "member_ids", Message: "user does not exist"}
+		}
+
+		now := time.Now()
+		query = `
+			INSERT INTO conversations (title, direct_key, created_by, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $4)
+			ON CONFLICT (direct_key) DO NOTHING
+			RETURNING id`
+		err := db.QueryRow(ctx, query, payload.Title, directKey, userID, now).Scan(&id)
+		if err == pgx.ErrNoRows {
+			// The direct conversation already exists.
+			created = false
+			return db.QueryRow(ctx, `SELECT id FROM conversations WHERE direct_key = $1`, directKey).Scan(&id)
+		}
+		if err != nil {
+			return err
+		}
+		created = true
+		query = `
+			INSERT INTO conversation_members (conversation_id, user_id, joined_at)
+			SELECT $1, unnest($2::uuid[]), $3`
+		_, err = db.Exec(ctx, query, id, members, now)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	conversation, err = getConversation(ctx, id, userID)
+	return conversation, created, err
+}
+
+const messageColumns = `m.id, m.conversation_id, m.user_id, m.content, m.created_at`
+
+func scanMessage(row pgx.Row, m *Message) error {
+	return row.Scan(&m.ID, &m.ConversationID, &m.UserID, &m.Content, &m.CreatedAt)
+}
+
+// isMember returns ErrNotFound unless userID is a member of the
+// conversation, so outsiders can't tell it exists.
+func isMember(ctx context.Context, conversationID string, userID string) error {
+	var member bool
+	query := `SELECT EXISTS (SELECT 1 FROM conversation_members WHERE conversation_id = $1 AND user_id = $2)`
+	if err := db.QueryRow(ctx, query, conversationID, userID).Scan(&member); err != nil {
+		return err
+	}
+	if !member {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetMessages lists the messages of a conversation of userID, newest first.
+func (c *Conversation) GetMessages(ctx context.Context, conversationID string, userID string, page Page) (*MessagesList, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+
+	afterTime, afterID, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+	if err := isMember(ctx, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m
+		WHERE m.conversation_id = $1
+			AND ($2::timestamptz IS NULL OR (m.created_at, m.id) < ($2, $3::uuid))
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $4`
+	rows, err := db.Query(ctx, query, conversationID, versionArg(afterTime), nullString(afterID), page.size())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := MessagesList{Messages: []Message{}}
+	for rows.Next() {
+		var m Message
+		if err := scanMessage(rows, &m); err != nil {
+			return nil, err
+		}
+		list.Messages = append(list.Messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if n := len(list.Messages); n == page.size() {
+		last := list.Messages[n-1]
+		list.NextCursor = cursorAt(last.CreatedAt, last.ID)
+	}
+	return &list, nil
+}
+
+// SendMessage posts a message from userID to one of their conversations,
+// which counts as the sender having read it. Members connected to the stream
+// get it as it commits.
+func (c *Conversation) SendMessage(ctx context.Context, conversationID string, userID string, payload MessagePayload) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	var m Message
+	err := WithTx(ctx, func(ctx context.Context) error {
+		query := `
+			INSERT INTO messages (conversation_id, user_id, content, created_at)
+			SELECT $1, $2, $3, $4
+			WHERE EXISTS (SELECT 1 FROM conversation_members WHERE conversation_id = $1 AND user_id = $2)
+			RETURNING id, conversation_id, user_id, content, created_at`
+		err := scanMessage(db.QueryRow(ctx, query, conversationID, userID, payload.Content, time.Now()), &m)
+		if err == pgx.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		query = `UPDATE conversations SET updated_at = $2 WHERE id = $1`
+		if _, err := db.Exec(ctx, query, conversationID, m.CreatedAt); err != nil {
+			return err
+		}
+		query = `UPDATE conversation_members SET last_read_message_id = $3, last_read_at = $4 WHERE conversation_id = $1 AND user_id = $2`
+		_, err = db.Exec(ctx, query, conversationID, userID, m.ID, m.CreatedAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// MarkRead records that userID read a conversation up to messageID and tells
+// the other members. Marking an older message read is a no-op.
+func (c *Conversation) MarkRead(ctx context.Context, conversationID string, userID string, messageID string) (*ReadReceipt, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	receipt := ReadReceipt{ConversationID: conversationID, UserID: userID}
+	err := WithTx(ctx, func(ctx context.Context) error {
+		if err := isMember(ctx, conversationID, userID); err != nil {
+			return err
+		}
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM messages WHERE id = $1 AND conversation_id = $2)`
+		if err := db.QueryRow(ctx, query, messageID, conversationID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return &ConstraintError{Kind: ErrInvalidReference, Field: "message_id", Message: "message does not exist in this conversation"}
+		}
+
+		query = `
+			UPDATE conversation_members cm SET last_read_message_id = m.id, last_read_at = m.created_at
+			FROM messages m
+			WHERE cm.conversation_id = $1 AND cm.user_id = $2 AND m.id = $3
+				AND (cm.last_read_at IS NULL OR (m.created_at, m.id) > (cm.last_read_at, cm.last_read_message_id))`
+		res, err := db.Exec(ctx, query, conversationID, userID, messageID)
+		if err != nil {
+			return err
+		}
+
+		query = `SELECT last_read_message_id, last_read_at FROM conversation_members WHERE conversation_id = $1 AND user_id = $2`
+		if err := db.QueryRow(ctx, query, conversationID, userID).Scan(&receipt.MessageID, &receipt.ReadAt); err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return nil
+		}
+		return announce(ctx, EventRead, conversationID, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// SetTyping tells the other members of a conversation that userID is typing.
+// Nothing is stored; clients show the indicator for a few seconds.
+func (c *Conversation) SetTyping(ctx context.Context, conversationID string, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	if err := isMember(ctx, conversationID, userID); err != nil {
+		return err
+	}
+	return announce(ctx, EventTyping, conversationID, userID)
+}
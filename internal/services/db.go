@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"challenge-api/internal/database"
+	"challenge-api/internal/metrics"
+	"challenge-api/internal/tracing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentedDB wraps the connection pool so every query issued by the
+// services is timed and traced, labeled with the service method that ran it.
+// Queries run inside the transaction carried by their context, if any, and
+// read-only queries may run on a replica.
+type instrumentedDB struct {
+	database.Pool
+	replicas *database.ReplicaSet
+}
+
+type readOnlyKey struct{}
+
+// readOnly marks ctx so the queries run with it may be served by a replica.
+// Read-only service methods call it first.
+func readOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+func (d *instrumentedDB) executor(ctx context.Context) database.Querier {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx
+	}
+	return d.Pool
+}
+
+// readBackoff spaces out the retries of read-only queries that failed to
+// reach the database.
+var readBackoff = database.Backoff{Attempts: 3, Initial: 50 * time.Millisecond, Max: 500 * time.Millisecond}
+
+// isReadOnly reports whether the queries run with ctx are idempotent reads,
+// which may run on a replica and be retried: ctx was marked by readOnly and
+// carries no transaction.
+func isReadOnly(ctx context.Context) bool {
+	if _, ok := txFromContext(ctx); ok {
+		return false
+	}
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
+
+// reader is like executor but picks a healthy replica for read-only queries,
+// unless the caller must read its own writes. The replica is returned so that
+// it can be marked down if it can't be reached.
+func (d *instrumentedDB) reader(ctx context.Context) (database.Querier, *database.Replica) {
+	if !isReadOnly(ctx) {
+		return d.executor(ctx), nil
+	}
+	if database.PrimaryRequired(ctx) {
+		return d.Pool, nil
+	}
+	if r := d.replicas.Pick(); r != nil {
+		return r.Pool, r
+	}
+	return d.Pool, nil
+}
+
+// retryRead reports whether a read-only query should run again after its
+// attempt-th try failed with err on replica (nil for the primary). A
+// replica that can't be reached is marked down so the retry goes elsewhere
+// right away; the primary is given a moment to recover.
+func retryRead(ctx context.Context, attempt int, replica *database.Replica, err error) bool {
+	if !database.Transient(ctx, err) || attempt >= readBackoff.Attempts {
+		return false
+	}
+	if replica != nil {
+		replica.MarkDown(err)
+		return true
+	}
+	return readBackoff.Wait(ctx, attempt) == nil
+}
+
+// Query retries read-only queries that failed to reach the database.
+func (d *instrumentedDB) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	name := queryName()
+	ctx, span := tracing.StartQuery(ctx, name, query)
+	start := time.Now()
+	q, replica := d.reader(ctx)
+	rows, err := q.Query(ctx, query, args...)
+	for attempt := 1; isReadOnly(ctx) && retryRead(ctx, attempt, replica, err); attempt++ {
+		q, replica = d.reader(ctx)
+		rows, err = q.Query(ctx, query, args...)
+	}
+	metrics.ObserveQuery(name, start, err)
+	tracing.EndQuery(span, err)
+	return rows, translateError(err)
+}
+
+// QueryRow defers timing, tracing and retries to Scan, since pgx only runs
+// the query then.
+func (d *instrumentedDB) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	name := queryName()
+	ctx, span := tracing.StartQuery(ctx, name, query)
+	q, replica := d.reader(ctx)
+	r := &row{
+		Row:   q.QueryRow(ctx, query, args...),
+		name:  name,
+		start: time.Now(),
+		span:  span,
+	}
+	if isReadOnly(ctx) {
+		r.retry = func(attempt int, err error) pgx.Row {
+			if !retryRead(ctx, attempt, replica, err) {
+				return nil
+			}
+			q, replica = d.reader(ctx)
+			return q.QueryRow(ctx, query, args...)
+		}
+	}
+	return r
+}
+
+func (d *instrumentedDB) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	name := queryName()
+	ctx, span := tracing.StartQuery(ctx, name, query)
+	start := time.Now()
+	tag, err := d.executor(ctx).Exec(ctx, query, args...)
+	metrics.ObserveQuery(name, start, err)
+	tracing.EndQuery(span, err)
+	return tag, translateError(err)
+}
+
+// SendBatch sends every statement queued in b in a single round trip. The
+// whole batch is timed and traced as one query.
+func (d *instrumentedDB) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	name := queryName()
+	ctx, span := tracing.StartQuery(ctx, name, fmt.Sprintf("batch of %d statements", b.Len()))
+	return &batchResults{
+		BatchResults: d.executor(ctx).SendBatch(ctx, b),
+		name:         name,
+		start:        time.Now(),
+		span:         span,
+	}
+}
+
+// row records the query once it is scanned and translates its errors.
+type row struct {
+	pgx.Row
+	name  string
+	start time.Time
+	span  trace.Span
+	// retry reruns the query after its attempt-th try failed with err, or
+	// returns nil when it shouldn't be retried.
+	retry func(attempt int, err error) pgx.Row
+}
+
+func (r *row) Scan(dest ...interface{}) error {
+	err := r.Row.Scan(dest...)
+	for attempt := 1; r.retry != nil && err != nil; attempt++ {
+		again := r.retry(attempt, err)
+		if again == nil {
+			break
+		}
+		err = again.Scan(dest...)
+	}
+	metrics.ObserveQuery(r.name, r.start, err)
+	tracing.EndQuery(r.span, err)
+	return translateError(err)
+}
+
+// batchResults records the batch once it is closed, which also reports the
+// first error of its statements, and translates their errors.
+type batchResults struct {
+	pgx.BatchResults
+	name  string
+	start time.Time
+	span  trace.Span
+	done  bool
+}
+
+func (b *batchResults) Exec() (pgconn.CommandTag, error) {
+	tag, err := b.BatchResults.Exec()
+	return tag, translateError(err)
+}
+
+// Close may be called more than once; the batch is only recorded the first
+// time.
+func (b *batchResults) Close() error {
+	err := b.BatchResults.Close()
+	if !b.done {
+		b.done = true
+		metrics.ObserveQuery(b.name, b.start, err)
+		tracing.EndQuery(b.span, err)
+	}
+	return translateError(err)
+}
+
+const servicesPkg = "challenge-api/internal/services."
+
+// queryName returns the service method that issued the query, e.g.
+// "Post.GetAllPosts", by walking the stack past the wrapper itself.
+func queryName() string {
+	pcs := make([]uintptr, 8)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		name, ok := strings.CutPrefix(frame.Function, servicesPkg)
+		if ok && !strings.HasPrefix(name, "(*instrumentedDB)") {
+			name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+			return name
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Diff operations.
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// DiffOp is a run of text kept, inserted or deleted when going from one
+// version of a text to the next. Joining the equal and delete runs gives the
+// old text back, and joining the equal and insert runs the new one.
+type DiffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxDiffCells bounds the work of diffWords, which grows with the product of
+// the word counts of both texts.
+const maxDiffCells = 1 << 20
+
+// diffWords compares two texts word by word, whitespace runs counting as
+// words, with a longest common subsequence. Texts too long to compare are
+// reported as replaced as a whole.
+func diffWords(from string, to string) []DiffOp {
+	a, b := splitWords(from), splitWords(to)
+	words := a
+
+	// Common ends are kept as they are and don't need the table.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := []DiffOp{}
+	ops = appendDiff(ops, DiffEqual, a[:prefix]...)
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		ops = appendDiff(ops, DiffDelete, a...)
+		ops = appendDiff(ops, DiffInsert, b...)
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of
+		// a[i:] and b[j:].
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && a[i] == b[j]:
+				ops = appendDiff(ops, DiffEqual, a[i])
+				i++
+				j++
+			case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+				ops = appendDiff(ops, DiffDelete, a[i])
+				i++
+			default:
+				ops = appendDiff(ops, DiffInsert, b[j])
+				j++
+			}
+		}
+	}
+	return appendDiff(ops, DiffEqual, words[len(words)-suffix:]...)
+}
+
+// appendDiff appends words to ops as a run of op, merging it with the last
+// run if it has the same op.
+func appendDiff(ops []DiffOp, op string, words ...string) []DiffOp {
+	if len(words) == 0 {
+		return ops
+	}
+	text := strings.Join(words, "")
+	if n := len(ops); n > 0 && ops[n-1].Op == op {
+		ops[n-1].Text += text
+		return ops
+	}
+	return append(ops, DiffOp{Op: op, Text: text})
+}
+
+// splitWords splits s into alternating runs of whitespace and of other
+// characters.
+func splitWords(s string) []string {
+	words := []string{}
+	start, space := 0, false
+	for i, r := range s {
+		if i > start && unicode.IsSpace(r) != space {
+			words = append(words, s[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(s) {
+		words = append(words, s[start:])
+	}
+	return words
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+// undiff rebuilds both texts from ops.
+func undiff(ops []DiffOp) (from string, to string) {
+	var a, b strings.Builder
+	for _, op := range ops {
+		if op.Op != DiffInsert {
+			a.WriteString(op.Text)
+		}
+		if op.Op != DiffDelete {
+			b.WriteString(op.Text)
+		}
+	}
+	return a.String(), b.String()
+}
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     []DiffOp
+	}{
+		{"unchanged", "same text", "same text", []DiffOp{{DiffEqual, "same text"}}},
+		{"both empty", "", "", []DiffOp{}},
+		{"from empty", "", "new post", []DiffOp{{DiffInsert, "new post"}}},
+		{"to empty", "old post", "", []DiffOp{{DiffDelete, "old post"}}},
+		{"insert", "hello world", "hello brave new world", []DiffOp{
+			{DiffEqual, "hello "}, {DiffInsert, "brave new "}, {DiffEqual, "world"},
+		}},
+		{"delete", "a very long day", "a day", []DiffOp{
+			{DiffEqual, "a "}, {DiffDelete, "very long "}, {DiffEqual, "day"},
+		}},
+		{"replace", "the cat sat", "the dog sat", []DiffOp{
+			{DiffEqual, "the "}, {DiffDelete, "cat"}, {DiffInsert, "dog"}, {DiffEqual, " sat"},
+		}},
+		{"whitespace only", "two  spaces", "two spaces", []DiffOp{
+			{DiffEqual, "two"}, {DiffDelete, "  "}, {DiffInsert, " "}, {DiffEqual, "spaces"},
+		}},
+		{"trailing newline", "line", "line\n", []DiffOp{{DiffEqual, "line"}, {DiffInsert, "\n"}}},
+		{"multibyte", "café au lait 🙂", "café noir 🙂", []DiffOp{
+			{DiffEqual, "café "}, {DiffDelete, "au lait"}, {DiffInsert, "noir"}, {DiffEqual, " 🙂"},
+		}},
+		{"multibyte whitespace", "a b", "a b", []DiffOp{
+			{DiffEqual, "a"}, {DiffDelete, " "}, {DiffInsert, " "}, {DiffEqual, "b"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := diffWords(tt.from, tt.to)
+			if from, to := undiff(ops); from != tt.from || to != tt.to {
+				t.Fatalf("ops %+v rebuild %q -> %q, want %q -> %q", ops, from, to, tt.from, tt.to)
+			}
+			if len(ops) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", ops, tt.want)
+			}
+			for i := range ops {
+				if ops[i] != tt.want[i] {
+					t.Fatalf("got %+v, want %+v", ops, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffWordsTooLong(t *testing.T) {
+	// Past maxDiffCells the changed middle is reported as replaced as a
+	// whole, while the common ends are still kept.
+	words := func(w string, n int) string {
+		return strings.TrimSpace(strings.Repeat(w+" ", n))
+	}
+	from := "start " + words("a", 1200) + " end"
+	to := "start " + words("b", 1200) + " end"
+	ops := diffWords(from, to)
+	want := []DiffOp{
+		{DiffEqual, "start "},
+		{DiffDelete, words("a", 1200)},
+		{DiffInsert, words("b", 1200)},
+		{DiffEqual, " end"},
+	}
+	if len(ops) != len(want) {
+		t.Fatalf("got %d ops, want %d", len(ops), len(want))
+	}
+	for i := range ops {
+		if ops[i] != want[i] {
+			t.Fatalf("op %d: got %q %.20q, want %q %.20q", i, ops[i].Op, ops[i].Text, want[i].Op, want[i].Text)
+		}
+	}
+	if f, tt := undiff(ops); f != from || tt != to {
+		t.Fatal("ops don't rebuild the texts")
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"one", []string{"one"}},
+		{" lead and trail ", []string{" ", "lead", " ", "and", " ", "trail", " "}},
+		{"tab\tand\nnewline", []string{"tab", "\t", "and", "\n", "newline"}},
+		{"ünï cödé", []string{"ünï", " ", "cödé"}},
+	}
+	for _, tt := range tests {
+		got := splitWords(tt.in)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
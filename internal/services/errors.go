@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+var (
+	ErrNotFound           = errors.New("resource not found")
+	ErrPreconditionFailed = errors.New("resource was modified since the given version")
+	// ErrConflict means the resource clashes with an existing one, e.g. a
+	// duplicate e-mail (unique_violation).
+	ErrConflict = errors.New("resource already exists")
+	// ErrInvalidReference means the resource points to another one that
+	// doesn't exist (foreign_key_violation).
+	ErrInvalidReference = errors.New("referenced resource does not exist")
+	// ErrInvalid means a value breaks a not-null or check constraint.
+	ErrInvalid = errors.New("invalid value")
+	// ErrInvalidCursor means a list position sent back by the client, a page
+	// cursor or a Last-Event-ID, wasn't issued by the API.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrUnauthorized means the credentials given are wrong or expired.
+	ErrUnauthorized = errors.New("invalid credentials")
+	// ErrForbidden means the caller may not act on the resource, e.g. edit
+	// a comment written by someone else.
+	ErrForbidden = errors.New("not allowed to modify this resource")
+)
+
+// ConstraintError is a constraint violation reported by Postgres, translated
+// into one of the domain errors above, which it unwraps to.
+type ConstraintError struct {
+	Kind       error
+	Constraint string
+	Field      string
+	Message    string
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s", e.Kind, e.Field)
+	}
+	return e.Kind.Error()
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Kind
+}
+
+// constraints describes the named constraints of the schema so violations
+// can point at the offending field with a readable message.
+var constraints = map[string]struct{ field, message string }{
+	"users_email_key":                   {"email", "email is already in use"},
+	"idx_user_name_on_users":            {"user_name", "user_name is already taken"},
+	"user_albums_pkey":                  {"album_id", "album is already saved by this user"},
+	"user_albums_user_id_fkey":          {"user_id", "user does not exist"},
+	"user_albums_album_id_fkey":         {"album_id", "album does not exist"},
+	"posts_user_id_fkey":                {"user_id", "user does not exist"},
+	"comments_post_id_fkey":             {"post_id", "post does not exist"},
+	"comments_user_id_fkey":             {"user_id", "user does not exist"},
+	"comments_parent_id_fkey":           {"parent_id", "parent comment does not exist"},
+	"comments_content_check":            {"content", "content must not be empty"},
+	"follows_no_self_follow":            {"id", "users cannot follow themselves"},
+	"messages_content_check":            {"content", "content must not be empty"},
+	"conversation_members_user_id_fkey": {"member_ids", "user does not exist"},
+}
+
+// translateError turns constraint violations reported by Postgres into a
+// *ConstraintError. Any other error is returned unchanged.
+func translateError(err error) error {
+	var kind error
+	switch sqlState(err) {
+	case "23505":
+		kind = ErrConflict
+	case "23503":
+		kind = ErrInvalidReference
+	case "23502", "23514":
+		kind = ErrInvalid
+	default:
+		return err
+	}
+
+	var constraint, column string
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		constraint, column = pgErr.ConstraintName, pgErr.ColumnName
+	}
+
+	ce := &ConstraintError{Kind: kind, Constraint: constraint, Field: column}
+	if c, ok := constraints[constraint]; ok {
+		ce.Field, ce.Message = c.field, c.message
+	} else if kind == ErrInvalid && column != "" {
+		ce.Message = fmt.Sprintf("%s must not be empty", column)
+	}
+	return ce
+}
+
+// conditionFailed explains why a conditional write on table matched no rows:
+// either the row doesn't exist (or was soft-deleted) or its version moved on.
+func conditionFailed(ctx context.Context, table string, id string) error {
+	var exists bool
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE id = $1 AND deleted_at IS NULL)`, table)
+	err := db.QueryRow(ctx, query, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrPreconditionFailed
+}
+
+// versionArg turns an expected version into a query argument; the zero time
+// becomes NULL, which the conditional queries treat as "any version".
+func versionArg(version time.Time) *time.Time {
+	if version.IsZero() {
+		return nil
+	}
+	return &version
+}
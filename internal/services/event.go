@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Event types.
+const (
+	EventNotification = "notification"
+	EventPost         = "post"
+	EventMessage      = "message"
+	EventTyping       = "typing"
+	EventRead         = "read"
+)
+
+// Event is something delivered to a user as it happens: one of their
+// notifications, a post by a user they follow, or activity in one of their
+// conversations. The ID of notification and post events is their position in
+// the stream event log, so a client can resume its stream after the last
+// event it saw; messages are reloaded through the REST endpoints instead, and
+// typing and read events are fleeting and have none.
+type Event struct {
+	ID        string
+	Type      string
+	Data      interface{}
+	CreatedAt time.Time
+}
+
+type Events struct{}
+
+// maxReplayedEvents caps how many missed events are replayed on resume. A
+// client further behind should reload its lists instead.
+const maxReplayedEvents = MaxPageSize
+
+// loggedEvent is an entry of the stream event log: subjectID names the post
+// or notification.
+type loggedEvent struct {
+	id        int64
+	kind      string
+	subjectID string
+}
+
+// Since returns, oldest first, the events of userID logged after the event
+// with ID cursor. An empty cursor has nothing to catch up on.
+//
+// The log is read on the primary: live events are loaded from it as they
+// are announced, and a lagging replica could miss some of those a client
+// already saw, and all those it didn't.
+func (e *Events) Since(ctx context.Context, userID string, cursor string) ([]Event, error) {
+	if cursor == "" {
+		return []Event{}, nil
+	}
+	after, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || after < 0 {
+		return nil, &ConstraintError{Kind: ErrInvalidCursor, Field: "Last-Event-ID", Message: "invalid Last-Event-ID"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT e.id, e.type, e.subject_id
+		FROM stream_events e
+		WHERE e.id > $2 AND (
+			(e.type = 'notification' AND e.user_id = $1)
+			OR (e.type = 'post' AND e.user_id IN (SELECT followee_id FROM follows WHERE follower_id = $1))
+		)
+		ORDER BY e.id
+		LIMIT $3`
+	rows, err := db.Query(ctx, query, userID, after, maxReplayedEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var logged []loggedEvent
+	var notificationIDs, postIDs []string
+	for rows.Next() {
+		var l loggedEvent
+		if err := rows.Scan(&l.id, &l.kind, &l.subjectID); err != nil {
+			return nil, err
+		}
+		logged = append(logged, l)
+		if l.kind == EventNotification {
+			notificationIDs = append(notificationIDs, l.subjectID)
+		} else {
+			postIDs = append(postIDs, l.subjectID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	subjects := make(map[string]interface{}, len(logged))
+	query = `SELECT ` + notificationColumns + ` FROM ` + liveNotifications + ` AND n.id = ANY($1::uuid[])`
+	rows, err = db.Query(ctx, query, notificationIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n Notification
+		if err := scanNotification(rows, &n); err != nil {
+			return nil, err
+		}
+		subjects[n.ID] = &n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ` + postColumns + `
+		FROM posts p
+		JOIN users u ON u.id = p.user_id AND u.deleted_at IS NULL
+		WHERE p.id = ANY($1::uuid[]) AND p.deleted_at IS NULL`
+	rows, err = db.Query(ctx, query, postIDs)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := scanPosts(ctx, rows, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		subjects[p.ID] = p
+	}
+
+	// Subjects deleted since are skipped.
+	events := []Event{}
+	for _, l := range logged {
+		switch subject := subjects[l.subjectID].(type) {
+		case *Notification:
+			events = append(events, notificationEvent(l.id, subject))
+		case *Post:
+			events = append(events, postEvent(l.id, subject))
+		}
+	}
+	return events, nil
+}
+
+// Notification returns the event logged as eventID for a notification that
+// was just created.
+func (e *Events) Notification(ctx context.Context, eventID int64, id string) (*Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `SELECT ` + notificationColumns + ` FROM ` + liveNotifications + ` AND n.id = $1`
+	var n Notification
+	err := scanNotification(db.QueryRow(ctx, query, id), &n)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	event := notificationEvent(eventID, &n)
+	return &event, nil
+}
+
+// Post returns the event logged as eventID for a post that was just created.
+func (e *Events) Post(ctx context.Context, eventID int64, id string) (*Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `SELECT ` + postColumns + ` FROM posts p WHERE p.id = $1 AND p.deleted_at IS NULL`
+	rows, err := db.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := scanPosts(ctx, rows, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, ErrNotFound
+	}
+	event := postEvent(eventID, posts[0])
+	return &event, nil
+}
+
+// Cleanup forgets the events logged before cutoff. Clients that were away
+// for longer reload their lists instead of resuming.
+func (e *Events) Cleanup(ctx context.Context, cutoff time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `DELETE FROM stream_events WHERE created_at < $1`
+	_, err := db.Exec(ctx, query, cutoff)
+	return err
+}
+
+func notificationEvent(eventID int64, n *Notification) Event {
+	return Event{ID: strconv.FormatInt(eventID, 10), Type: EventNotification, Data: n, CreatedAt: n.CreatedAt}
+}
+
+func postEvent(eventID int64, p *Post) Event {
+	return Event{ID: strconv.FormatInt(eventID, 10), Type: EventPost, Data: p, CreatedAt: p.CreatedAt}
+}
+
+// Message returns the event for a message that was just sent, and the
+// members among candidates, who receive it. The message isn't loaded when
+// none of them is a member.
+func (e *Events) Message(ctx context.Context, id string, candidates []string) (*Event, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `
+		SELECT cm.user_id FROM messages m
+		JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+		WHERE m.id = $1 AND cm.user_id = ANY($2::uuid[])`
+	members, err := queryUserIDs(ctx, query, id, candidates)
+	if err != nil || len(members) == 0 {
+		return nil, nil, err
+	}
+	query = `SELECT ` + messageColumns + ` FROM messages m WHERE m.id = $1`
+	var m Message
+	err = scanMessage(db.QueryRow(ctx, query, id), &m)
+	if err == pgx.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	event := Event{ID: cursorAt(m.CreatedAt, m.ID), Type: EventMessage, Data: &m, CreatedAt: m.CreatedAt}
+	return &event, members, nil
+}
+
+// Typing returns the event for userID typing in a conversation, and the other
+// members among candidates, who receive it.
+func (e *Events) Typing(ctx context.Context, conversationID string, userID string, candidates []string) (*Event, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	members, err := conversationMembers(ctx, conversationID, candidates)
+	if err != nil {
+		return nil, nil, err
+	}
+	others := members[:0]
+	for _, id := range members {
+		if id != userID {
+			others = append(others, id)
+		}
+	}
+	if len(others) == 0 {
+		return nil, nil, nil
+	}
+	event := Event{Type: EventTyping, Data: &Typing{ConversationID: conversationID, UserID: userID}, CreatedAt: time.Now()}
+	return &event, others, nil
+}
+
+// ReadReceipt returns the event for how far userID read a conversation, and
+// the members among candidates, who receive it. The receipt isn't loaded
+// when none of them is a member.
+func (e *Events) ReadReceipt(ctx context.Context, conversationID string, userID string, candidates []string) (*Event, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	members, err := conversationMembers(ctx, conversationID, candidates)
+	if err != nil || len(members) == 0 {
+		return nil, nil, err
+	}
+	receipt := ReadReceipt{ConversationID: conversationID, UserID: userID}
+	query := `SELECT last_read_message_id, last_read_at FROM conversation_members WHERE conversation_id = $1 AND user_id = $2`
+	err = db.QueryRow(ctx, query, conversationID, userID).Scan(&receipt.MessageID, &receipt.ReadAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	event := Event{Type: EventRead, Data: &receipt, CreatedAt: time.Now()}
+	return &event, members, nil
+}
+
+// conversationMembers returns which of candidates are members of a
+// conversation.
+func conversationMembers(ctx context.Context, conversationID string, candidates []string) ([]string, error) {
+	query := `SELECT user_id FROM conversation_members WHERE conversation_id = $1 AND user_id = ANY($2::uuid[])`
+	return queryUserIDs(ctx, query, conversationID, candidates)
+}
+
+// queryUserIDs runs a query selecting a single column of user IDs.
+func queryUserIDs(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// announce publishes an event on the stream_events channel, in the same form
+// as the triggers of the stream_events migration, for events that aren't an
+// inserted row. Inside a transaction it is only sent on commit.
+func announce(ctx context.Context, kind string, id string, userID string) error {
+	query := `SELECT pg_notify('stream_events', json_build_object('type', $1::text, 'id', $2::uuid, 'user_id', $3::uuid)::text)`
+	_, err := db.Exec(ctx, query, kind, id, userID)
+	return err
+}
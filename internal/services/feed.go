@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+)
+
+// Feed is the home timeline of a user: the posts of the users they follow.
+//
+// It is built on read (fan-out-on-read): each request merges the newest posts
+// of the followed users, walking idx_user_id_created_at_on_posts once per
+// followee. That keeps writes cheap and is fast while users follow a modest
+// number of accounts. Should that stop holding, posts can be pushed on write
+// into a timeline table keyed by (user_id, created_at, post_id) and GetFeed
+// read from it instead; the cursor is the same (created_at, id) pair, so
+// clients won't notice the switch.
+type Feed struct{}
+
+// GetFeed lists, newest first, the live posts of the users userID follows.
+func (f *Feed) GetFeed(ctx context.Context, userID string, page Page) (*PostsPage, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+
+	afterTime, afterID, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+
+	// Each followee contributes at most a page of their newest posts, read
+	// off the index, so only those are sorted rather than every followed post.
+	query := `
+		SELECT ` + postColumns + `
+		FROM follows f
+		JOIN users u ON u.id = f.followee_id AND u.deleted_at IS NULL
+		CROSS JOIN LATERAL (
+			SELECT id, user_id, content, created_at, updated_at
+			FROM posts
+			WHERE user_id = f.followee_id AND deleted_at IS NULL
+				AND ($2::timestamptz IS NULL OR (created_at, id) < ($2, $3::uuid))
+			ORDER BY created_at DESC, id DESC
+			LIMIT $4
+		) p
+		WHERE f.follower_id = $1
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT $4`
+	rows, err := db.Query(ctx, query, userID, versionArg(afterTime), nullString(afterID), page.size())
+	if err != nil {
+		return nil, err
+	}
+	posts, err := scanPosts(ctx, rows, userID)
+	if err != nil {
+		return nil, err
+	}
+	return postsPage(posts, page), nil
+}
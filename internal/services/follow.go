@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+type Follow struct{}
+
+// FollowUser is an entry of a followers or following list.
+type FollowUser struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	UserName   string    `json:"user_name"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type FollowList struct {
+	Users      []FollowUser `json:"users"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// FollowStatus tells whether the caller follows a user after following or
+// unfollowing them.
+type FollowStatus struct {
+	UserID         string `json:"user_id"`
+	Following      bool   `json:"following"`
+	FollowersCount int    `json:"followers_count"`
+}
+
+// FollowUser makes followerID follow followeeID and notifies followeeID.
+// Following twice is a no-op.
+func (f *Follow) FollowUser(ctx context.Context, followerID string, followeeID string) (*FollowStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	err := WithTx(ctx, func(ctx context.Context) error {
+		query := `
+			INSERT INTO follows (follower_id, followee_id)
+			SELECT $1, $2
+			WHERE EXISTS (SELECT 1 FROM users WHERE id = $2 AND deleted_at IS NULL)
+			ON CONFLICT DO NOTHING`
+		res, err := db.Exec(ctx, query, followerID, followeeID)
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			// Either the user is gone or they were already followed.
+			if err := conditionFailed(ctx, "users", followeeID); err != ErrPreconditionFailed {
+				return err
+			}
+			return nil
+		}
+		return notify(ctx, []string{followeeID}, notice{Type: NotificationFollow, ActorID: followerID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return followStatus(ctx, followerID, followeeID)
+}
+
+// UnfollowUser makes followerID stop following followeeID, if they did.
+func (f *Follow) UnfollowUser(ctx context.Context, followerID string, followeeID string) (*FollowStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	if err := conditionFailed(ctx, "users", followeeID); err != ErrPreconditionFailed {
+		return nil, err
+	}
+	query := `DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2`
+	_, err := db.Exec(ctx, query, followerID, followeeID)
+	if err != nil {
+		return nil, err
+	}
+	return followStatus(ctx, followerID, followeeID)
+}
+
+func followStatus(ctx context.Context, followerID string, followeeID string) (*FollowStatus, error) {
+	status := FollowStatus{UserID: followeeID}
+	query := `
+		SELECT
+			EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2),
+			(SELECT COUNT(*) FROM follows f JOIN users u ON u.id = f.follower_id AND u.deleted_at IS NULL WHERE f.followee_id = $2)`
+	err := db.QueryRow(ctx, query, followerID, followeeID).Scan(&status.Following, &status.FollowersCount)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// FollowersAmong returns which of candidates follow followeeID.
+func (f *Follow) FollowersAmong(ctx context.Context, followeeID string, candidates []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `SELECT follower_id FROM follows WHERE followee_id = $1 AND follower_id = ANY($2::uuid[])`
+	rows, err := db.Query(ctx, query, followeeID, candidates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var followers []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		followers = append(followers, id)
+	}
+	return followers, rows.Err()
+}
+
+// GetFollowers lists the users following userID, most recent first.
+func (f *Follow) GetFollowers(ctx context.Context, userID string, page Page) (*FollowList, error) {
+	return listFollows(ctx, userID, page, "followee_id", "follower_id")
+}
+
+// GetFollowing lists the users userID follows, most recent first.
+func (f *Follow) GetFollowing(ctx context.Context, userID string, page Page) (*FollowList, error) {
+	return listFollows(ctx, userID, page, "follower_id", "followee_id")
+}
+
+// listFollows lists the follows whose by column is userID, returning the
+// users in their other column.
+func listFollows(ctx context.Context, userID string, page Page, by string, other string) (*FollowList, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+
+	afterTime, afterID, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+	if err := conditionFailed(ctx, "users", userID); err != ErrPreconditionFailed {
+		return nil, err
+	}
+
+	query := `
+		SELECT u.id, u.name, u.user_name, f.created_at
+		FROM follows f
+		JOIN users u ON u.id = f.` + other + ` AND u.deleted_at IS NULL
+		WHERE f.` + by + ` = $1
+			AND ($2::timestamptz IS NULL OR (f.created_at, f.` + other + `) < ($2, $3::uuid))
+		ORDER BY f.created_at DESC, f.` + other + ` DESC
+		LIMIT $4`
+	rows, err := db.Query(ctx, query, userID, versionArg(afterTime), nullString(afterID), page.size())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := FollowList{Users: []FollowUser{}}
+	for rows.Next() {
+		var u FollowUser
+		if err := rows.Scan(&u.ID, &u.Name, &u.UserName, &u.FollowedAt); err != nil {
+			return nil, err
+		}
+		list.Users = append(list.Users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if n := len(list.Users); n == page.size() {
+		last := list.Users[n-1]
+		list.NextCursor = cursorAt(last.FollowedAt, last.ID)
+	}
+	return &list, nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+type Hashtag struct{}
+
+// TrendingHashtag is a hashtag with the number of posts and distinct authors
+// that used it within the trending window.
+type TrendingHashtag struct {
+	Name  string `json:"name"`
+	Posts int    `json:"posts"`
+	Users int    `json:"users"`
+}
+
+const maxHashtagLength = 100
+
+// hashtagPattern matches a # that doesn't follow a word character, and the
+// word after it.
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{M}\p{N}_&#])#([\p{L}\p{M}\p{N}_]+)`)
+
+// NormalizeHashtag folds a hashtag, with or without its leading #, to the
+// form it is stored in: lower case and without accents.
+func NormalizeHashtag(tag string) string {
+	fold := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(fold, strings.TrimPrefix(tag, "#"))
+	if err != nil {
+		folded = tag
+	}
+	return strings.ToLower(folded)
+}
+
+// extractHashtags returns the distinct normalized hashtags of a post's
+// content. Tags made only of digits or underscores ("#1") and overly long
+// ones are not hashtags.
+func extractHashtags(content string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, m := range hashtagPattern.FindAllStringSubmatch(content, -1) {
+		tag := NormalizeHashtag(m[1])
+		if seen[tag] || len(tag) > maxHashtagLength || strings.IndexFunc(tag, unicode.IsLetter) < 0 {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// tagPost makes the hashtags of a post match those in its content. It must
+// run in the transaction that wrote the content.
+func tagPost(ctx context.Context, postID string, content string) error {
+	tags := extractHashtags(content)
+	query := `
+		DELETE FROM post_hashtags ph USING hashtags h
+		WHERE ph.hashtag_id = h.id AND ph.post_id = $1 AND h.name <> ALL($2::text[])`
+	if _, err := db.Exec(ctx, query, postID, tags); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	query = `INSERT INTO hashtags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`
+	if _, err := db.Exec(ctx, query, tags); err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO post_hashtags (post_id, hashtag_id, created_at)
+		SELECT p.id, h.id, p.created_at
+		FROM posts p, hashtags h
+		WHERE p.id = $1 AND h.name = ANY($2::text[])
+		ON CONFLICT DO NOTHING`
+	_, err := db.Exec(ctx, query, postID, tags)
+	return err
+}
+
+// BackfillHashtags tags a batch of the posts written before hashtags were
+// tracked: up to limit posts after the one with ID after, in ID order. It
+// returns the ID of the last post of the batch, to pass as after for the
+// next one, or "" when done. Posts already tagged are left as they are.
+func (h *Hashtag) BackfillHashtags(ctx context.Context, after string, limit int) (string, error) {
+	return reindexPosts(ctx, after, limit, func(ctx context.Context, post *Post) error {
+		return tagPost(ctx, post.ID, post.Content)
+	})
+}
+
+// GetPostsByHashtag lists, newest first, the live posts tagged with tag.
+func (h *Hashtag) GetPostsByHashtag(ctx context.Context, tag string, viewerID string, page Page) (*PostsPage, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+
+	afterTime, afterID, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM hashtags h
+		JOIN post_hashtags ph ON ph.hashtag_id = h.id
+		JOIN posts p ON p.id = ph.post_id AND p.deleted_at IS NULL
+		WHERE h.name = $1
+			AND ($2::timestamptz IS NULL OR (ph.created_at, ph.post_id) < ($2, $3::uuid))
+		ORDER BY ph.created_at DESC, ph.post_id DESC
+		LIMIT $4`
+	rows, err := db.Query(ctx, query, NormalizeHashtag(tag), versionArg(afterTime), nullString(afterID), page.size())
+	if err != nil {
+		return nil, err
+	}
+	posts, err := scanPosts(ctx, rows, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	return postsPage(posts, page), nil
+}
+
+// Trending lists the hashtags used by the most live posts created since
+// since, breaking ties by the number of distinct authors.
+func (h *Hashtag) Trending(ctx context.Context, since time.Time, limit int) ([]TrendingHashtag, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+	query := `
+		SELECT h.name, COUNT(*), COUNT(DISTINCT p.user_id)
+		FROM post_hashtags ph
+		JOIN hashtags h ON h.id = ph.hashtag_id
+		JOIN posts p ON p.id = ph.post_id AND p.deleted_at IS NULL
+		WHERE ph.created_at >= $1
+		GROUP BY h.name
+		ORDER BY COUNT(*) DESC, COUNT(DISTINCT p.user_id) DESC, h.name
+		LIMIT $2`
+	rows, err := db.Query(ctx, query, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trending := []TrendingHashtag{}
+	for rows.Next() {
+		var t TrendingHashtag
+		if err := rows.Scan(&t.Name, &t.Posts, &t.Users); err != nil {
+			return nil, err
+		}
+		trending = append(trending, t)
+	}
+	return trending, rows.Err()
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHashtag(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"#Go", "go"},
+		{"go", "go"},
+		{"#São", "sao"},
+		{"#CAFÉ", "cafe"},
+		// Precomposed and combining accents fold to the same tag.
+		{"#cafe\u0301", "cafe"},
+		{"#Ação", "acao"},
+		{"#Ñandú", "nandu"},
+		// Only nonspacing marks go: letters without a decomposition stay.
+		{"#Straße", "straße"},
+		{"#Ørsted", "ørsted"},
+		{"#東京", "東京"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeHashtag(tt.in); got != tt.want {
+			t.Errorf("NormalizeHashtag(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"none", "no tags here", []string{}},
+		{"simple", "#go is fun", []string{"go"}},
+		{"several", "#go and #rust, #zig!", []string{"go", "rust", "zig"}},
+		{"deduplicated", "#Café #cafe\u0301 #CAFE", []string{"cafe"}},
+		{"trailing punctuation", "love #golang. and #sql?", []string{"golang", "sql"}},
+		{"inside word", "issue#42 and c#sharp", []string{}},
+		{"html entity", "&#39;quoted&#39;", []string{}},
+		{"double hash", "##double", []string{}},
+		{"digits only", "#1 and #2024", []string{}},
+		{"letters and digits", "#web3 #_x", []string{"web3", "_x"}},
+		{"unicode", "#東京 #São_Paulo", []string{"東京", "sao_paulo"}},
+		{"too long", "#" + strings.Repeat("a", maxHashtagLength+1), []string{}},
+		{"longest", "#" + strings.Repeat("a", maxHashtagLength), []string{strings.Repeat("a", maxHashtagLength)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractHashtags(tt.content)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || len(got) != len(tt.want) {
+				t.Errorf("extractHashtags(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"challenge-api/migrations"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type Health struct{}
+
+type PoolStats struct {
+	MaxConns             int32  `json:"max_conns"`
+	TotalConns           int32  `json:"total_conns"`
+	AcquiredConns        int32  `json:"acquired_conns"`
+	IdleConns            int32  `json:"idle_conns"`
+	AcquireCount         int64  `json:"acquire_count"`
+	AcquireDuration      string `json:"acquire_duration"`
+	EmptyAcquireCount    int64  `json:"empty_acquire_count"`
+	CanceledAcquireCount int64  `json:"canceled_acquire_count"`
+}
+
+type MigrationStatus struct {
+	Current  int64 `json:"current"`
+	Expected int64 `json:"expected"`
+}
+
+type HealthReport struct {
+	Status     string            `json:"status"`
+	Checks     map[string]string `json:"checks"`
+	Migrations *MigrationStatus  `json:"migrations,omitempty"`
+	Pool       *PoolStats        `json:"pool,omitempty"`
+}
+
+// Ready pings the database and checks that the schema is at the latest
+// embedded migration, and reports which replicas are in rotation. The report
+// is always returned; ok is false when any of the checks failed.
+func (h *Health) Ready(ctx context.Context) (report *HealthReport, ok bool) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	report = &HealthReport{
+		Status: "ok",
+		Checks: map[string]string{},
+		Pool:   poolStats(),
+	}
+	ok = true
+	fail := func(check string, err error) {
+		report.Checks[check] = err.Error()
+		report.Status = "unavailable"
+		ok = false
+	}
+
+	if err := db.Ping(ctx); err != nil {
+		fail("database", err)
+		return report, ok
+	}
+	report.Checks["database"] = "ok"
+
+	expected, err := migrations.Latest()
+	if err != nil {
+		fail("migrations", err)
+		return report, ok
+	}
+	var current int64
+	query := `SELECT COALESCE(MAX(version), 0) FROM _sqlx_migrations WHERE success`
+	err = db.QueryRow(ctx, query).Scan(&current)
+	if err != nil {
+		fail("migrations", err)
+		return report, ok
+	}
+	report.Migrations = &MigrationStatus{Current: current, Expected: expected}
+	if current != expected {
+		fail("migrations", fmt.Errorf("database is at version %d, expected %d", current, expected))
+		return report, ok
+	}
+	report.Checks["migrations"] = "ok"
+
+	// Replicas being down doesn't make the API unready: reads fall back to
+	// the primary.
+	if db.replicas != nil {
+		for _, r := range db.replicas.Replicas {
+			report.Checks[r.Name] = "ok"
+			if !r.Healthy() {
+				report.Checks[r.Name] = "down, reading from primary"
+			}
+		}
+	}
+
+	return report, ok
+}
+
+// poolStats reports the pgx pool statistics, or nil when the services run
+// on a backend that doesn't keep any.
+func poolStats() *PoolStats {
+	p, ok := db.Pool.(interface{ Stat() *pgxpool.Stat })
+	if !ok {
+		return nil
+	}
+	s := p.Stat()
+	return &PoolStats{
+		MaxConns:             s.MaxConns(),
+		TotalConns:           s.TotalConns(),
+		AcquiredConns:        s.AcquiredConns(),
+		IdleConns:            s.IdleConns(),
+		AcquireCount:         s.AcquireCount(),
+		AcquireDuration:      s.AcquireDuration().String(),
+		EmptyAcquireCount:    s.EmptyAcquireCount(),
+		CanceledAcquireCount: s.CanceledAcquireCount(),
+	}
+}
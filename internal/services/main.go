@@ -1,11 +1,12 @@
 package services
 
 import (
-	"database/sql"
 	"time"
+
+	"challenge-api/internal/database"
 )
 
-var db*sql.DB
+var db *instrumentedDB
 
 const dbTimeout = 5 * time.Second
 
@@ -13,10 +14,21 @@ type Models struct {
 	Users User
 	Albums Album
 	Posts Post
+	Comments Comment
+	Reactions Reaction
+	Follows Follow
+	Feed Feed
+	Hashtags Hashtag
+	Notifications Notification
+	Conversations Conversation
+	Events Events
+	Sessions Session
 	JsonResponse JsonResponseModel
 }
 
-func New(dbPool * sql.DB) Models{
-	db = dbPool
+// New sets the pool the services run on. Read-only queries are spread over
+// replicas, if any, falling back to dbPool.
+func New(dbPool database.Pool, replicas *database.ReplicaSet) Models{
+	db = &instrumentedDB{Pool: dbPool, replicas: replicas}
 	return Models{}
 }
\ No newline at end of file
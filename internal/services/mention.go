@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mention is an @user_name token of a post that names an existing user.
+// Offset and Length are in Unicode code points.
+type Mention struct {
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	UserID string `json:"user_id"`
+}
+
+// mentionPattern matches an @ that doesn't follow a word character or an
+// e-mail local part, and the user name after it.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@])(@[\p{L}\p{N}_.\-]+)`)
+
+type mentionToken struct {
+	UserName string
+	Offset   int
+	Length   int
+}
+
+// extractMentions returns the @user_name tokens of a post's content. A
+// trailing dot ends the sentence rather than the name.
+func extractMentions(content string) []mentionToken {
+	var tokens []mentionToken
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		token := strings.TrimRight(content[m[2]:m[3]], ".")
+		if len(token) < 2 {
+			continue
+		}
+		tokens = append(tokens, mentionToken{
+			UserName: token[1:],
+			Offset:   utf8.RuneCountInString(content[:m[2]]),
+			Length:   utf8.RuneCountInString(token),
+		})
+	}
+	return tokens
+}
+
+// mentionPost replaces the mentions of a post with those in its content and
+// notifies the users mentioned for the first time. Tokens that don't name a
+// live user are left as plain text. It must run in the transaction that wrote
+// the content.
+func mentionPost(ctx context.Context, postID string, authorID string, content string) ([]Mention, error) {
+	mentions, added, err := storeMentions(ctx, postID, content)
+	if err != nil {
+		return nil, err
+	}
+	err = notify(ctx, added, notice{Type: NotificationMention, ActorID: authorID, PostID: &postID})
+	if err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
+
+// BackfillMentions stores the mentions of a batch of the posts written before
+// mentions were tracked: up to limit posts after the one with ID after, in ID
+// order. Nobody is notified of those old mentions. It returns the ID of the
+// last post of the batch, to pass as after for the next one, or "" when done.
+func (p *Post) BackfillMentions(ctx context.Context, after string, limit int) (string, error) {
+	return reindexPosts(ctx, after, limit, func(ctx context.Context, post *Post) error {
+		_, _, err := storeMentions(ctx, post.ID, post.Content)
+		return err
+	})
+}
+
+// storeMentions replaces the mentions of a post with those in its content,
+// and returns them along with the users that weren't mentioned before.
+func storeMentions(ctx context.Context, postID string, content string) ([]Mention, []string, error) {
+	query := `DELETE FROM post_mentions WHERE post_id = $1 RETURNING user_id`
+	rows, err := db.Query(ctx, query, postID)
+	if err != nil {
+		return nil, nil, err
+	}
+	previous := map[string]bool{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		previous[userID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	mentions := []Mention{}
+	tokens := extractMentions(content)
+	if len(tokens) == 0 {
+		return mentions, nil, nil
+	}
+
+	// One lookup for all the names rather than GetUserByUsername per token.
+	names := make([]string, len(tokens))
+	for i, t := range tokens {
+		names[i] = t.UserName
+	}
+	query = `SELECT id, user_name FROM users WHERE user_name = ANY($1::text[]) AND deleted_at IS NULL`
+	rows, err = db.Query(ctx, query, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := map[string]string{}
+	for rows.Next() {
+		var id, userName string
+		if err := rows.Scan(&id, &userName); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		ids[userName] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var userIDs []string
+	var offsets, lengths []int
+	var recipients []string
+	for _, t := range tokens {
+		id, ok := ids[t.UserName]
+		if !ok {
+			continue
+		}
+		mentions = append(mentions, Mention{Offset: t.Offset, Length: t.Length, UserID: id})
+		userIDs = append(userIDs, id)
+		offsets = append(offsets, t.Offset)
+		lengths = append(lengths, t.Length)
+		if !previous[id] {
+			previous[id] = true
+			recipients = append(recipients, id)
+		}
+	}
+	if len(mentions) == 0 {
+		return mentions, nil, nil
+	}
+
+	query = `
+		INSERT INTO post_mentions (post_id, user_id, "offset", length)
+		SELECT $1, m.user_id, m."offset", m.length
+		FROM unnest($2::uuid[], $3::int[], $4::int[]) AS m(user_id, "offset", length)`
+	if _, err := db.Exec(ctx, query, postID, userIDs, offsets, lengths); err != nil {
+		return nil, nil, err
+	}
+	return mentions, recipients, nil
+}
+
+// attachMentions fills in the mentions of posts in a single query.
+func attachMentions(ctx context.Context, posts []*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]string, len(posts))
+	byID := make(map[string]*Post, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+		byID[p.ID] = p
+		p.Mentions = []Mention{}
+	}
+	query := `
+		SELECT m.post_id, m."offset", m.length, m.user_id
+		FROM post_mentions m
+		JOIN users u ON u.id = m.user_id AND u.deleted_at IS NULL
+		WHERE m.post_id = ANY($1::uuid[])
+		ORDER BY m.post_id, m."offset"`
+	rows, err := db.Query(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID string
+		var m Mention
+		if err := rows.Scan(&postID, &m.Offset, &m.Length, &m.UserID); err != nil {
+			return err
+		}
+		p := byID[postID]
+		p.Mentions = append(p.Mentions, m)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []mentionToken
+	}{
+		{"none", "no mentions", nil},
+		{"start", "@alice hi", []mentionToken{{"alice", 0, 6}}},
+		{"middle", "hi @alice!", []mentionToken{{"alice", 3, 6}}},
+		{"several", "@bob, @carol and @dave", []mentionToken{{"bob", 0, 4}, {"carol", 6, 6}, {"dave", 17, 5}}},
+		{"trailing dot", "thanks @bob.", []mentionToken{{"bob", 7, 4}}},
+		{"trailing dots", "well @bob...", []mentionToken{{"bob", 5, 4}}},
+		{"dot inside", "ping @john.doe.", []mentionToken{{"john.doe", 5, 9}}},
+		{"trailing punctuation", "@bob? (@carol) @dave: @eve;", []mentionToken{
+			{"bob", 0, 4}, {"carol", 7, 6}, {"dave", 15, 5}, {"eve", 22, 4},
+		}},
+		{"hyphen and underscore", "@mary-jane_2", []mentionToken{{"mary-jane_2", 0, 12}}},
+		{"email", "write to a@b or john.doe@example.com", nil},
+		{"after digit", "room 1@floor", nil},
+		{"double at", "@@bob", nil},
+		{"lone at", "at @ noon, @. done", nil},
+		{"rune offsets", "ação @eve", []mentionToken{{"eve", 5, 4}}},
+		{"astral rune offsets", "🙂🙂 @zoe", []mentionToken{{"zoe", 3, 4}}},
+		{"unicode name", "oi @joão", []mentionToken{{"joão", 3, 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractMentions(tt.content)
+			if len(got) != len(tt.want) {
+				t.Fatalf("extractMentions(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("extractMentions(%q) = %+v, want %+v", tt.content, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Notification types.
+const (
+	NotificationFollow      = "follow"
+	NotificationComment     = "comment"
+	NotificationMention     = "mention"
+	NotificationAlbumShared = "album_shared"
+)
+
+// Notification tells a user that someone else did something involving them:
+// followed them (follow), commented on their post or replied to their comment
+// (comment), mentioned them in a post (mention) or saved an album for them
+// (album_shared). The subject IDs set depend on the type.
+type Notification struct {
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	ActorID       string     `json:"actor_id"`
+	ActorUserName string     `json:"actor_user_name"`
+	PostID        *string    `json:"post_id,omitempty"`
+	CommentID     *string    `json:"comment_id,omitempty"`
+	AlbumID       *string    `json:"album_id,omitempty"`
+	Read          bool       `json:"read"`
+	ReadAt        *time.Time `json:"read_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type NotificationsList struct {
+	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+	UnreadCount   int            `json:"unread_count"`
+}
+
+// notice is a notification about to be sent: what actorID did, and to which
+// post, comment or album.
+type notice struct {
+	Type      string
+	ActorID   string
+	PostID    *string
+	CommentID *string
+	AlbumID   *string
+}
+
+// notify sends n to each of recipients, except the actor. It runs in the
+// caller's transaction, if any, so a notification only exists if what it is
+// about was written.
+func notify(ctx context.Context, recipients []string, n notice) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO notifications (user_id, actor_id, type, post_id, comment_id, album_id)
+		SELECT DISTINCT r, $2::uuid, $3, $4::uuid, $5::uuid, $6::uuid
+		FROM unnest($1::uuid[]) AS r
+		WHERE r <> $2::uuid`
+	_, err := db.Exec(ctx, query, recipients, n.ActorID, n.Type, n.PostID, n.CommentID, n.AlbumID)
+	return err
+}
+
+// liveNotifications joins the notifications n with their actor a, leaving out
+// those whose actor, post or comment was deleted since.
+const liveNotifications = `
+	notifications n
+	JOIN users a ON a.id = n.actor_id AND a.deleted_at IS NULL
+	LEFT JOIN posts p ON p.id = n.post_id
+	LEFT JOIN comments c ON c.id = n.comment_id
+	WHERE p.deleted_at IS NULL AND c.deleted_at IS NULL`
+
+const notificationColumns = `n.id, n.type, n.actor_id, a.user_name, n.post_id, n.comment_id, n.album_id, n.read_at, n.created_at`
+
+func scanNotification(row pgx.Row, n *Notification) error {
+	err := row.Scan(&n.ID, &n.Type, &n.ActorID, &n.ActorUserName, &n.PostID, &n.CommentID, &n.AlbumID, &n.ReadAt, &n.CreatedAt)
+	n.Read = n.ReadAt != nil
+	return err
+}
+
+// GetNotifications lists userID's notifications, newest first, only the
+// unread ones if unreadOnly is set.
+func (n *Notification) GetNotifications(ctx context.Context, userID string, unreadOnly bool, page Page) (*NotificationsList, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+
+	afterTime, afterID, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + notificationColumns + `
+		FROM ` + liveNotifications + `
+			AND n.user_id = $1
+			AND (NOT $2 OR n.read_at IS NULL)
+			AND ($3::timestamptz IS NULL OR (n.created_at, n.id) < ($3, $4::uuid))
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $5`
+	rows, err := db.Query(ctx, query, userID, unreadOnly, versionArg(afterTime), nullString(afterID), page.size())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := NotificationsList{Notifications: []Notification{}}
+	for rows.Next() {
+		var n Notification
+		if err := scanNotification(rows, &n); err != nil {
+			return nil, err
+		}
+		list.Notifications = append(list.Notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if n := len(list.Notifications); n == page.size() {
+		last := list.Notifications[n-1]
+		list.NextCursor = cursorAt(last.CreatedAt, last.ID)
+	}
+
+	list.UnreadCount, err = unreadCount(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// UnreadCount returns the number of notifications userID hasn't read.
+func (n *Notification) UnreadCount(ctx context.Context, userID string) (int, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+	return unreadCount(ctx, userID)
+}
+
+func unreadCount(ctx context.Context, userID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM ` + liveNotifications + ` AND n.user_id = $1 AND n.read_at IS NULL`
+	err := db.QueryRow(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks one of userID's notifications as read. Marking it again is a
+// no-op.
+func (n *Notification) MarkRead(ctx context.Context, id string, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `UPDATE notifications SET read_at = COALESCE(read_at, $3) WHERE id = $1 AND user_id = $2`
+	res, err := db.Exec(ctx, query, id, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks all of userID's notifications created up to before as
+// read, so ones arriving while the client catches up stay unread. A zero
+// before marks all of them. It returns how many were marked.
+func (n *Notification) MarkAllRead(ctx context.Context, userID string, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `
+		UPDATE notifications SET read_at = $2
+		WHERE user_id = $1 AND read_at IS NULL AND ($3::timestamptz IS NULL OR created_at <= $3)`
+	res, err := db.Exec(ctx, query, userID, time.Now(), versionArg(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
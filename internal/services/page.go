@@ -0,0 +1,65 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// uuidPattern matches the canonical form of a UUID, the one cursorAt writes.
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Page selects a slice of a list ordered by creation time. Cursor is the
+// NextCursor of the previous page, empty for the first one.
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+// size returns the page size, clamped to MaxPageSize.
+func (p Page) size() int {
+	switch {
+	case p.Limit <= 0:
+		return DefaultPageSize
+	case p.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return p.Limit
+}
+
+// after decodes the cursor into the position of the last item of the
+// previous page. The zero time and an empty id mean the list starts over.
+// Cursors are opaque to clients, so a malformed one, e.g. with an id that
+// isn't a UUID, is rejected here rather than by Postgres.
+func (p Page) after() (time.Time, string, error) {
+	if p.Cursor == "" {
+		return time.Time{}, "", nil
+	}
+	invalid := &ConstraintError{Kind: ErrInvalidCursor, Field: "cursor", Message: "invalid cursor"}
+	raw, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return time.Time{}, "", invalid
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, "", invalid
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil || !uuidPattern.MatchString(id) {
+		return time.Time{}, "", invalid
+	}
+	return t, id, nil
+}
+
+// cursorAt returns the cursor of the page that follows the item created at t
+// with the given id.
+func cursorAt(t time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%s", t.UTC().Format(time.RFC3339Nano), id)))
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPageAfter(t *testing.T) {
+	at := time.Date(2026, 10, 19, 12, 30, 0, 123456000, time.UTC)
+	id := "3f2504e0-4f89-11d3-9a0c-0305e82c3301"
+
+	gotTime, gotID, err := Page{Cursor: cursorAt(at, id)}.after()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotTime.Equal(at) || gotID != id {
+		t.Errorf("after() = %v, %q, want %v, %q", gotTime, gotID, at, id)
+	}
+
+	gotTime, gotID, err = Page{}.after()
+	if err != nil || !gotTime.IsZero() || gotID != "" {
+		t.Errorf("empty cursor: after() = %v, %q, %v", gotTime, gotID, err)
+	}
+
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	invalid := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!!"},
+		{"no separator", encode("2026-10-19T12:30:00Z")},
+		{"bad time", encode("yesterday|" + id)},
+		{"empty id", encode("2026-10-19T12:30:00Z|")},
+		{"id not a uuid", encode("2026-10-19T12:30:00Z|42")},
+		{"id with sql", encode("2026-10-19T12:30:00Z|" + id + "' OR '1'='1")},
+		{"id in braces", encode("2026-10-19T12:30:00Z|{" + id + "}")},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Page{Cursor: tt.cursor}.after()
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("after() error = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
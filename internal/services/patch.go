@@ -0,0 +1,45 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// patchSet collects the columns supplied by a JSON merge patch.
+type patchSet struct {
+	cols []string
+	args []interface{}
+}
+
+// set adds col to the update when the patch supplied a value for it.
+func (p *patchSet) set(col string, v *string) {
+	if v == nil {
+		return
+	}
+	p.cols = append(p.cols, col)
+	p.args = append(p.args, *v)
+}
+
+// query returns a conditional UPDATE of the row id in table touching only the
+// supplied columns, and its arguments. An empty patch leaves the row, and its
+// version, untouched but still checks the version.
+func (p *patchSet) query(table string, id string, version time.Time, returning string) (string, []interface{}) {
+	sets := make([]string, 0, len(p.cols)+1)
+	args := make([]interface{}, 0, len(p.args)+3)
+	for i, col := range p.cols {
+		sets = append(sets, fmt.Sprintf("%s = $%d", col, i+1))
+		args = append(args, p.args[i])
+	}
+	if len(p.cols) > 0 {
+		args = append(args, time.Now())
+		sets = append(sets, fmt.Sprintf("updated_at = $%d", len(args)))
+	} else {
+		sets = append(sets, "updated_at = updated_at")
+	}
+	args = append(args, id, versionArg(version))
+	n := len(args)
+	query := fmt.Sprintf(`UPDATE %s SET %s WHERE id = $%d AND deleted_at IS NULL AND ($%d::timestamptz IS NULL OR updated_at = $%d) RETURNING %s`,
+		table, strings.Join(sets, ", "), n-1, n, n, returning)
+	return query, args
+}
@@ -3,6 +3,8 @@ package services
 import (
 	"context"
 	"time"
+
+	"github.com/jackc/pgx/v4"
 )
 
 type Post struct {
@@ -11,6 +13,16 @@ type Post struct {
 	Content		string `json:"content"`
 	CreatedAt 	time.Time `json:"created_at"`
 	UpdatedAt 	time.Time `json:"updated_at"`
+	// Reactions counts the reactions by type and MyReactions lists the ones
+	// left by the viewer. Only set when reading posts.
+	Reactions   map[string]int `json:"reactions"`
+	MyReactions []string       `json:"my_reactions"`
+	// Mentions locates the users named with @user_name in Content.
+	Mentions []Mention `json:"mentions"`
+	// Edited tells whether the content was ever changed, and RevisionCount
+	// how many earlier versions of it are kept (see GetPostRevisions).
+	Edited        bool `json:"edited"`
+	RevisionCount int  `json:"revision_count"`
 }
 
 type PostPayload struct {
@@ -18,20 +30,42 @@ type PostPayload struct {
 	Content		string `json:"content"`
 }
 
+// PostPatch is a JSON merge patch for a post: omitted fields are left
+// untouched.
+type PostPatch struct {
+	Content *string `json:"content,omitempty"`
+}
+
 type PostsList struct {
 	Posts []Post `json:"posts"`
 }
 
+// PostsPage is a page of a list of posts ordered newest first.
+type PostsPage struct {
+	Posts      []*Post `json:"posts"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
 
-func (p *Post) GetAllPosts() ([]*Post, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-	query := `SELECT id, user_id, content, created_at, updated_at FROM posts`
-	rows, err := db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
+func postsPage(posts []*Post, page Page) *PostsPage {
+	result := PostsPage{Posts: posts}
+	if n := len(posts); n == page.size() {
+		last := posts[n-1]
+		result.NextCursor = cursorAt(last.CreatedAt, last.ID)
 	}
-	var posts []*Post
+	return &result
+}
+
+// postColumns are the columns scanPosts reads, in order.
+const postColumns = `p.id, p.user_id, p.content, p.created_at, p.updated_at, ` + revisionCount
+
+// revisionCount selects how many revisions the post p has.
+const revisionCount = `(SELECT COUNT(*) FROM post_revisions r WHERE r.post_id = p.id)`
+
+// scanPosts reads the posts selected with postColumns, then fills in their
+// reactions as seen by viewerID.
+func scanPosts(ctx context.Context, rows pgx.Rows, viewerID string) ([]*Post, error) {
+	defer rows.Close()
+	posts := []*Post{}
 	for rows.Next() {
 		var post Post
 		err := rows.Scan(
@@ -40,88 +74,245 @@ func (p *Post) GetAllPosts() ([]*Post, error) {
 			&post.Content,
 			&post.CreatedAt,
 			&post.UpdatedAt,
+			&post.RevisionCount,
 		)
 		if err != nil {
 			return nil, err
 		}
+		post.Edited = post.RevisionCount > 0
 		posts = append(posts, &post)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := attachReactions(ctx, posts, viewerID); err != nil {
+		return nil, err
+	}
+	if err := attachMentions(ctx, posts); err != nil {
+		return nil, err
+	}
 	return posts, nil
 }
 
-func (p *Post) GetPostByID(id string) (*Post, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// indexPost stores the hashtags and mentions found in a post's content, in
+// the transaction that wrote it, and sets post.Mentions.
+func indexPost(ctx context.Context, post *Post) error {
+	if err := tagPost(ctx, post.ID, post.Content); err != nil {
+		return err
+	}
+	mentions, err := mentionPost(ctx, post.ID, post.UserID, post.Content)
+	if err != nil {
+		return err
+	}
+	post.Mentions = mentions
+	return nil
+}
+
+// reindexPosts runs index over the posts, deleted ones included, that come
+// after the post with ID after, in ID order, up to limit of them. Each post is
+// indexed in its own transaction, which it is read in. It returns the ID of
+// the last post, or "" once there are none left.
+func reindexPosts(ctx context.Context, after string, limit int, index func(ctx context.Context, post *Post) error) (string, error) {
+	query := `SELECT id FROM posts WHERE ($1::uuid IS NULL OR id > $1::uuid) ORDER BY id LIMIT $2`
+	rows, err := db.Query(ctx, query, nullString(after), limit)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	for _, id := range ids {
+		err := WithTx(ctx, func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+			defer cancel()
+			var post Post
+			query := `SELECT id, user_id, content FROM posts WHERE id = $1`
+			err := db.QueryRow(ctx, query, id).Scan(&post.ID, &post.UserID, &post.Content)
+			if err == pgx.ErrNoRows {
+				// Purged meanwhile.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return index(ctx, &post)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(ids) == 0 {
+		return "", nil
+	}
+	return ids[len(ids)-1], nil
+}
+
+// GetAllPosts lists every post with its reactions as seen by viewerID, who
+// may be empty for anonymous callers.
+func (p *Post) GetAllPosts(ctx context.Context, viewerID string) ([]*Post, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
 	defer cancel()
-	query := `SELECT id, user_id, content, created_at, updated_at FROM posts WHERE id = $1`
-	row := db.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&p.ID,
-		&p.UserID,
-		&p.Content,
-		&p.CreatedAt,
-		&p.UpdatedAt,
-	)
+	query := `SELECT ` + postColumns + ` FROM posts p WHERE p.deleted_at IS NULL`
+	rows, err := db.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
-	return p, nil
+	return scanPosts(ctx, rows, viewerID)
 }
 
-func (p *Post) CreatePost(post Post) (*Post, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// GetPostByID returns a post with its reactions as seen by viewerID.
+func (p *Post) GetPostByID(ctx context.Context, id string, viewerID string) (*Post, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
 	defer cancel()
-	query := `INSERT INTO posts (user_id, content, created_at, updated_at) VALUES ($1, $2, $3, $4) RETURNING id`
-	err := db.QueryRowContext(ctx, query, post.UserID, post.Content, time.Now(), time.Now()).Scan(&post.ID)
+	query := `SELECT ` + postColumns + ` FROM posts p WHERE p.id = $1 AND p.deleted_at IS NULL`
+	rows, err := db.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := scanPosts(ctx, rows, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, ErrNotFound
+	}
+	return posts[0], nil
+}
+
+// CreatePost adds a post, tagging it with the hashtags in its content and
+// notifying the users it mentions.
+func (p *Post) CreatePost(ctx context.Context, post Post) (*Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	err := WithTx(ctx, func(ctx context.Context) error {
+		query := `INSERT INTO posts (user_id, content, created_at, updated_at) SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL) RETURNING id`
+		err := db.QueryRow(ctx, query, post.UserID, post.Content, time.Now(), time.Now()).Scan(&post.ID)
+		if err == pgx.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return indexPost(ctx, &post)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// UpdatePost overwrites the post content if it is still at version (its
+// last update time), keeping the replaced content as a revision and
+// reindexing the hashtags and mentions of the new content. A zero version
+// updates unconditionally.
+func (p *Post) UpdatePost(ctx context.Context, id string, post Post, version time.Time) (*Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	err := WithTx(ctx, func(ctx context.Context) error {
+		if err := revisePost(ctx, id, post.Content); err != nil {
+			return err
+		}
+		query := `UPDATE posts p SET content = $1, updated_at = $2 WHERE id = $3 AND deleted_at IS NULL AND ($4::timestamptz IS NULL OR updated_at = $4) RETURNING ` + postColumns
+		err := db.QueryRow(ctx, query, post.Content, time.Now(), id, versionArg(version)).Scan(&post.ID, &post.UserID, &post.Content, &post.CreatedAt, &post.UpdatedAt, &post.RevisionCount)
+		if err == pgx.ErrNoRows {
+			return conditionFailed(ctx, "posts", id)
+		}
+		if err != nil {
+			return err
+		}
+		post.Edited = post.RevisionCount > 0
+		return indexPost(ctx, &post)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &post, nil
 }
 
-func (p *Post) UpdatePost(id string, post Post) (*Post, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// PatchPost applies patch to the post if it is still at version and returns
+// the stored post, keeping the replaced content as a revision and reindexing
+// the new one. A zero version patches unconditionally.
+func (p *Post) PatchPost(ctx context.Context, id string, patch PostPatch, version time.Time) (*Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
-	query := `UPDATE posts SET content = $1, updated_at = $2 WHERE id = $3 RETURNING id, user_id, content, created_at, updated_at`
-	err := db.QueryRowContext(ctx, query, post.Content, time.Now(), id).Scan(&post.ID, &post.UserID, &post.Content, &post.CreatedAt, &post.UpdatedAt)
+	var set patchSet
+	set.set("content", patch.Content)
+	query, args := set.query("posts p", id, version, postColumns)
+	var post Post
+	err := WithTx(ctx, func(ctx context.Context) error {
+		if patch.Content != nil {
+			if err := revisePost(ctx, id, *patch.Content); err != nil {
+				return err
+			}
+		}
+		err := db.QueryRow(ctx, query, args...).Scan(&post.ID, &post.UserID, &post.Content, &post.CreatedAt, &post.UpdatedAt, &post.RevisionCount)
+		if err == pgx.ErrNoRows {
+			return conditionFailed(ctx, "posts", id)
+		}
+		if err != nil {
+			return err
+		}
+		post.Edited = post.RevisionCount > 0
+		return indexPost(ctx, &post)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &post, nil
 }
 
-func (p *Post) DeletePost(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// DeletePost soft-deletes the post if it is still at version. A zero version
+// deletes unconditionally.
+func (p *Post) DeletePost(ctx context.Context, id string, version time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
-	query := `DELETE FROM posts WHERE id = $1`
-	_, err := db.ExecContext(ctx, query, id)
+	query := `UPDATE posts SET deleted_at = $3 WHERE id = $1 AND deleted_at IS NULL AND ($2::timestamptz IS NULL OR updated_at = $2)`
+	res, err := db.Exec(ctx, query, id, versionArg(version), time.Now())
 	if err != nil {
 		return err
 	}
+	if res.RowsAffected() == 0 {
+		return conditionFailed(ctx, "posts", id)
+	}
 	return nil
 }
 
-func (p *Post) GetPostsByUserID(id string) ([]*Post, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (p *Post) GetPostsByUserID(ctx context.Context, id string, viewerID string) ([]*Post, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
 	defer cancel()
-	query := `SELECT id, user_id, content, created_at, updated_at FROM posts WHERE user_id = $1`
-	rows, err := db.QueryContext(ctx, query, id)
+	query := `SELECT ` + postColumns + ` FROM posts p WHERE p.user_id = $1 AND p.deleted_at IS NULL`
+	rows, err := db.Query(ctx, query, id)
 	if err != nil {
 		return nil, err
 	}
-	var posts []*Post
-	for rows.Next() {
-		var post Post
-		err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Content,
-			&post.CreatedAt,
-			&post.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		posts = append(posts, &post)
+	return scanPosts(ctx, rows, viewerID)
+}
+
+// RestorePost undoes the soft deletion of a post. Posts of a deleted user
+// can only come back by restoring the user.
+func (p *Post) RestorePost(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `
+		UPDATE posts SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		  AND EXISTS (SELECT 1 FROM users WHERE users.id = posts.user_id AND users.deleted_at IS NULL)`
+	res, err := db.Exec(ctx, query, id)
+	if err != nil {
+		return err
 	}
-	return posts, nil
-}
\ No newline at end of file
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
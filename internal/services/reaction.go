@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ReactionTypes are the reactions a user can leave on a post, once each.
+var ReactionTypes = []string{"like", "love", "haha", "wow", "sad", "angry"}
+
+type Reaction struct{}
+
+// ReactionSummary is what a post's reactions add up to for one viewer.
+type ReactionSummary struct {
+	PostID      string         `json:"post_id"`
+	Counts      map[string]int `json:"reactions"`
+	MyReactions []string       `json:"my_reactions"`
+}
+
+func validReaction(kind string) error {
+	for _, t := range ReactionTypes {
+		if t == kind {
+			return nil
+		}
+	}
+	return &ConstraintError{
+		Kind:    ErrInvalid,
+		Field:   "type",
+		Message: fmt.Sprintf("reaction must be one of %s", strings.Join(ReactionTypes, ", ")),
+	}
+}
+
+// React records userID's reaction of the given kind on the post. Reacting
+// twice with the same kind is a no-op.
+func (re *Reaction) React(ctx context.Context, postID string, userID string, kind string) (*ReactionSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	if err := validReaction(kind); err != nil {
+		return nil, err
+	}
+	query := `
+		INSERT INTO post_reactions (post_id, user_id, type)
+		SELECT $1, $2, $3
+		WHERE EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL)
+		ON CONFLICT DO NOTHING`
+	res, err := db.Exec(ctx, query, postID, userID, kind)
+	if err != nil {
+		return nil, err
+	}
+	if res.RowsAffected() == 0 {
+		// Either the post is gone or the reaction was already there.
+		if err := conditionFailed(ctx, "posts", postID); err != ErrPreconditionFailed {
+			return nil, err
+		}
+	}
+	return reactionSummary(ctx, postID, userID)
+}
+
+// Unreact removes userID's reaction of the given kind from the post, if any.
+func (re *Reaction) Unreact(ctx context.Context, postID string, userID string, kind string) (*ReactionSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	if err := validReaction(kind); err != nil {
+		return nil, err
+	}
+	if err := conditionFailed(ctx, "posts", postID); err != ErrPreconditionFailed {
+		return nil, err
+	}
+	query := `DELETE FROM post_reactions WHERE post_id = $1 AND user_id = $2 AND type = $3`
+	_, err := db.Exec(ctx, query, postID, userID, kind)
+	if err != nil {
+		return nil, err
+	}
+	return reactionSummary(ctx, postID, userID)
+}
+
+func reactionSummary(ctx context.Context, postID string, userID string) (*ReactionSummary, error) {
+	post := &Post{ID: postID}
+	if err := attachReactions(ctx, []*Post{post}, userID); err != nil {
+		return nil, err
+	}
+	return &ReactionSummary{PostID: postID, Counts: post.Reactions, MyReactions: post.MyReactions}, nil
+}
+
+// attachReactions fills in the reaction counts of posts, and the reactions
+// left by viewerID (if not empty), in a single query. Reactions of deleted
+// users aren't counted.
+func attachReactions(ctx context.Context, posts []*Post, viewerID string) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]string, len(posts))
+	byID := make(map[string]*Post, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+		byID[p.ID] = p
+		p.Reactions = map[string]int{}
+		p.MyReactions = []string{}
+	}
+	query := `
+		SELECT r.post_id, r.type, COUNT(*), COALESCE(BOOL_OR(r.user_id = $2::uuid), FALSE)
+		FROM post_reactions r
+		JOIN users u ON u.id = r.user_id AND u.deleted_at IS NULL
+		WHERE r.post_id = ANY($1::uuid[])
+		GROUP BY r.post_id, r.type
+		ORDER BY r.post_id, r.type`
+	rows, err := db.Query(ctx, query, ids, nullString(viewerID))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID, kind string
+		var count int
+		var mine bool
+		if err := rows.Scan(&postID, &kind, &count, &mine); err != nil {
+			return err
+		}
+		p := byID[postID]
+		p.Reactions[kind] = count
+		if mine {
+			p.MyReactions = append(p.MyReactions, kind)
+		}
+	}
+	return rows.Err()
+}
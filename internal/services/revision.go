@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// PostRevision is an earlier version of a post's content. Diff lists the
+// changes the edit that replaced it made, ending with the next revision or
+// the current content.
+type PostRevision struct {
+	Revision   int       `json:"revision"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+	Diff       []DiffOp  `json:"diff"`
+}
+
+type PostRevisionsList struct {
+	Revisions []PostRevision `json:"revisions"`
+}
+
+// revisePost keeps the current content of the post id as its next revision,
+// in the transaction of an edit about to replace it with content. The post is
+// locked until the edit commits. Edits that leave the content as it is don't
+// make a revision.
+func revisePost(ctx context.Context, id string, content string) error {
+	var current string
+	var updatedAt time.Time
+	query := `SELECT content, updated_at FROM posts WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err := db.QueryRow(ctx, query, id).Scan(&current, &updatedAt)
+	if err == pgx.ErrNoRows {
+		// The edit reports the missing post.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current == content {
+		return nil
+	}
+	query = `
+		INSERT INTO post_revisions (post_id, revision, content, created_at, replaced_at)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4
+		FROM post_revisions
+		WHERE post_id = $1`
+	_, err = db.Exec(ctx, query, id, current, updatedAt, time.Now())
+	return err
+}
+
+// GetPostRevisions lists the earlier versions of a post's content, newest
+// first, each with the changes made by the edit that replaced it.
+func (p *Post) GetPostRevisions(ctx context.Context, id string) ([]*PostRevision, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
+	defer cancel()
+
+	var content string
+	err := db.QueryRow(ctx, `SELECT content FROM posts WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&content)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT revision, content, created_at, replaced_at
+		FROM post_revisions
+		WHERE post_id = $1
+		ORDER BY revision DESC`
+	rows, err := db.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []*PostRevision{}
+	for rows.Next() {
+		var r PostRevision
+		if err := rows.Scan(&r.Revision, &r.Content, &r.CreatedAt, &r.ReplacedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Newest first, each revision was replaced by the one before it.
+	next := content
+	for _, r := range revisions {
+		r.Diff = diffWords(r.Content, next)
+		next = r.Content
+	}
+	return revisions, nil
+}
+
+// RevertPost restores the content of revision of a post written by userID,
+// if the post is still at version. Like any edit, the content it replaces
+// becomes a new revision, so a revert can itself be reverted. A zero version
+// reverts unconditionally.
+func (p *Post) RevertPost(ctx context.Context, id string, userID string, revision int, version time.Time) (*Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	var post *Post
+	err := WithTx(ctx, func(ctx context.Context) error {
+		var authorID string
+		var content *string
+		query := `
+			SELECT p.user_id, r.content
+			FROM posts p
+			LEFT JOIN post_revisions r ON r.post_id = p.id AND r.revision = $2
+			WHERE p.id = $1 AND p.deleted_at IS NULL`
+		err := db.QueryRow(ctx, query, id, revision).Scan(&authorID, &content)
+		if err == pgx.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if authorID != userID {
+			return ErrForbidden
+		}
+		if content == nil {
+			return &ConstraintError{Kind: ErrNotFound, Field: "revision", Message: "revision does not exist"}
+		}
+		post, err = p.UpdatePost(ctx, id, Post{Content: *content}, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
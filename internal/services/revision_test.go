@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// fakePool answers QueryRow with a fixed row and records the statements run
+// with Exec. Anything else fails the test.
+type fakePool struct {
+	t     *testing.T
+	row   []interface{}
+	execs []string
+}
+
+func (p *fakePool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	p.execs = append(p.execs, sql)
+	return pgconn.CommandTag("INSERT 0 1"), nil
+}
+
+func (p *fakePool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return fakeRow(p.row)
+}
+
+func (p *fakePool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	p.t.Fatalf("unexpected query: %s", sql)
+	return nil, nil
+}
+
+func (p *fakePool) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	p.t.Fatal("unexpected batch")
+	return nil
+}
+
+func (p *fakePool) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	return nil, errors.New("no transactions in tests")
+}
+
+func (p *fakePool) Ping(ctx context.Context) error { return nil }
+
+// fakeRow scans its values, or reports pgx.ErrNoRows when nil.
+type fakeRow []interface{}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r == nil {
+		return pgx.ErrNoRows
+	}
+	for i, v := range r {
+		switch d := dest[i].(type) {
+		case *string:
+			*d = v.(string)
+		case *time.Time:
+			*d = v.(time.Time)
+		}
+	}
+	return nil
+}
+
+func TestRevisePost(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   fakeRow
+		content   string
+		revisions int
+	}{
+		{"changed", fakeRow{"old", time.Now()}, "new", 1},
+		{"unchanged", fakeRow{"same", time.Now()}, "same", 0},
+		{"whitespace change", fakeRow{"a b", time.Now()}, "a  b", 1},
+		{"missing post", nil, "new", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &fakePool{t: t, row: tt.current}
+			saved := db
+			db = &instrumentedDB{Pool: pool}
+			t.Cleanup(func() { db = saved })
+			if err := revisePost(context.Background(), "post", tt.content); err != nil {
+				t.Fatal(err)
+			}
+			inserts := 0
+			for _, sql := range pool.execs {
+				if strings.Contains(sql, "INSERT INTO post_revisions") {
+					inserts++
+				}
+			}
+			if inserts != tt.revisions {
+				t.Errorf("got %d revisions, want %d", inserts, tt.revisions)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Session struct {
+	Token     string    `json:"token"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type Credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// dummyHash is compared against when the e-mail is unknown, so that a login
+// takes as long whether or not the account exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// hashToken returns the form a token is stored in, so that a leaked sessions
+// table can't be used to log in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Login checks the credentials and opens a session lasting ttl. Wrong
+// credentials return ErrUnauthorized.
+func (s *Session) Login(ctx context.Context, creds Credentials, ttl time.Duration) (*Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var userID string
+	var hash []byte
+	query := `SELECT id, password FROM users WHERE email = $1 AND deleted_at IS NULL`
+	err := db.QueryRow(ctx, query, creds.Email).Scan(&userID, &hash)
+	if err == pgx.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(creds.Password))
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(creds.Password)) != nil {
+		return nil, ErrUnauthorized
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	session := Session{
+		Token:     base64.RawURLEncoding.EncodeToString(raw),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	query = `INSERT INTO sessions (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`
+	_, err = db.Exec(ctx, query, hashToken(session.Token), session.UserID, session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Authenticate returns the ID of the user holding token, or ErrUnauthorized
+// when the session doesn't exist, expired or belongs to a deleted user.
+func (s *Session) Authenticate(ctx context.Context, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	var userID string
+	query := `
+		SELECT s.user_id FROM sessions s
+		JOIN users u ON u.id = s.user_id AND u.deleted_at IS NULL
+		WHERE s.token_hash = $1 AND s.expires_at > NOW()`
+	err := db.QueryRow(ctx, query, hashToken(token)).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return "", ErrUnauthorized
+	}
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// Logout ends the session holding token.
+func (s *Session) Logout(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `DELETE FROM sessions WHERE token_hash = $1`
+	_, err := db.Exec(ctx, query, hashToken(token))
+	return err
+}
+
+// Cleanup deletes expired sessions.
+func (s *Session) Cleanup(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `DELETE FROM sessions WHERE expires_at < NOW()`
+	_, err := db.Exec(ctx, query)
+	return err
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Trash manages soft-deleted users, posts and albums.
+type Trash struct{}
+
+type PurgeResult struct {
+	Users    int64 `json:"users"`
+	Posts    int64 `json:"posts"`
+	Albums   int64 `json:"albums"`
+	Comments int64 `json:"comments"`
+}
+
+// Purge permanently deletes the rows soft-deleted before cutoff, sending the
+// deletes in a single batch. Deleting a user cascades to their posts,
+// comments and saved albums. A deleted comment is kept while it still has
+// live replies, since purging it would take the replies with it, and so is a
+// deleted user while another user's live reply hangs off one of their
+// comments outside their own posts.
+func (t *Trash) Purge(ctx context.Context, cutoff time.Time) (*PurgeResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var batch pgx.Batch
+	batch.Queue(`DELETE FROM users u WHERE deleted_at < $1
+		AND NOT EXISTS (
+			SELECT 1 FROM comments c
+			JOIN comments r ON r.parent_id = c.id AND r.user_id <> u.id AND r.deleted_at IS NULL
+			JOIN posts p ON p.id = r.post_id AND p.user_id <> u.id
+			WHERE c.user_id = u.id)`, cutoff)
+	batch.Queue(`DELETE FROM posts WHERE deleted_at < $1`, cutoff)
+	batch.Queue(`DELETE FROM albums WHERE deleted_at < $1`, cutoff)
+	batch.Queue(`DELETE FROM comments c WHERE deleted_at < $1
+		AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id AND r.deleted_at IS NULL)`, cutoff)
+	results := db.SendBatch(ctx, &batch)
+	defer results.Close()
+
+	var result PurgeResult
+	for _, count := range []*int64{&result.Users, &result.Posts, &result.Albums, &result.Comments} {
+		tag, err := results.Exec()
+		if err != nil {
+			return nil, err
+		}
+		*count = tag.RowsAffected()
+	}
+	return &result, results.Close()
+}
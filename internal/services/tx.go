@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const maxTxAttempts = 5
+
+type txKey struct{}
+
+// txFromContext returns the transaction started by WithTx, if any.
+func txFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+// WithTx runs fn in a serializable transaction. Service methods called with
+// the context passed to fn run inside that transaction, so several of them
+// commit or roll back together. The transaction is retried from the start
+// when Postgres aborts it with a serialization failure or a deadlock, so fn
+// must not have side effects outside the database. Nested calls join the
+// outer transaction.
+func WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := txFromContext(ctx); ok {
+		return fn(ctx)
+	}
+
+	var err error
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(rand.Int63n(int64(10*time.Millisecond) << attempt))
+			select {
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			case <-time.After(backoff):
+			}
+		}
+		err = runTx(ctx, fn)
+		if !isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func runTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := db.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback(ctx)
+			panic(p)
+		}
+	}()
+
+	err = fn(context.WithValue(ctx, txKey{}, tx))
+	if err != nil {
+		return errors.Join(err, ignoreDone(tx.Rollback(ctx)))
+	}
+	return translateError(tx.Commit(ctx))
+}
+
+func ignoreDone(err error) error {
+	if errors.Is(err, pgx.ErrTxClosed) {
+		return nil
+	}
+	return err
+}
+
+// isRetryable reports whether err aborted a transaction that is safe to run
+// again: serialization_failure (40001) or deadlock_detected (40P01).
+func isRetryable(err error) bool {
+	switch sqlState(err) {
+	case "40001", "40P01":
+		return true
+	}
+	return false
+}
+
+// sqlState returns the SQLSTATE code of a Postgres error, or "" for other
+// errors.
+func sqlState(err error) string {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState()
+	}
+	return ""
+}
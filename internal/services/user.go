@@ -4,10 +4,10 @@ import (
 	"context"
 	"time"
 	"math/rand"
-	"errors"
-	"database/sql"
-	"log"
 
+	"challenge-api/internal/logging"
+
+	"github.com/jackc/pgx/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -21,21 +21,40 @@ type User struct {
 	City	  string    `json:"city"`
 	WeekDays  string    `json:"week_days"`
 	UserName  string    `json:"user_name"`
+	// FollowersCount and FollowingCount are only set when reading users.
+	FollowersCount int `json:"followers_count"`
+	FollowingCount int `json:"following_count"`
 }
 type UserPayload struct {
 	Name     string `json:"name"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+// UserPatch is a JSON merge patch for a user: omitted fields are left
+// untouched.
+type UserPatch struct {
+	Name     *string `json:"name,omitempty"`
+	Email    *string `json:"email,omitempty"`
+	City     *string `json:"city,omitempty"`
+	WeekDays *string `json:"week_days,omitempty"`
+	UserName *string `json:"user_name,omitempty"`
+}
+
 type UsersList struct {
 	Users []User `json:"users"`
 }
 
-func (u *User) GetAllUsers() ([]*User, error) {
-    ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// followCounts selects how many live users follow, and are followed by, the
+// row of the users table.
+const followCounts = `
+	(SELECT COUNT(*) FROM follows f JOIN users fu ON fu.id = f.follower_id AND fu.deleted_at IS NULL WHERE f.followee_id = users.id),
+	(SELECT COUNT(*) FROM follows f JOIN users fu ON fu.id = f.followee_id AND fu.deleted_at IS NULL WHERE f.follower_id = users.id)`
+
+func (u *User) GetAllUsers(ctx context.Context) ([]*User, error) {
+    ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
     defer cancel()
-    query := `SELECT id, name, email, created_at, updated_at, city, week_days, user_name FROM users`
-    rows, err := db.QueryContext(ctx, query)
+    query := `SELECT id, name, email, created_at, updated_at, city, week_days, user_name, ` + followCounts + ` FROM users WHERE deleted_at IS NULL`
+    rows, err := db.Query(ctx, query)
     if err != nil {
         return nil, err
     }
@@ -53,6 +72,8 @@ func (u *User) GetAllUsers() ([]*User, error) {
             &user.City,
             &user.WeekDays,
             &user.UserName, // Adicione o campo user_name aqui
+            &user.FollowersCount,
+            &user.FollowingCount,
         )
         if err != nil {
             return nil, err
@@ -66,31 +87,34 @@ func (u *User) GetAllUsers() ([]*User, error) {
 }
 
 
-func (u *User) GetUserByID(id string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) GetUserByID(ctx context.Context, id string) (*User, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
 	defer cancel()
-	query := `SELECT id, name, email, created_at, updated_at FROM users WHERE id = $1`
-	row := db.QueryRowContext(ctx, query, id)
+	query := `SELECT id, name, email, created_at, updated_at, ` + followCounts + ` FROM users WHERE id = $1 AND deleted_at IS NULL`
+	var user User
+	row := db.QueryRow(ctx, query, id)
 	err := row.Scan(
-		&u.ID,
-		&u.Name,
-		&u.Email,
-		&u.CreatedAt,
-		&u.UpdatedAt,
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.FollowersCount,
+		&user.FollowingCount,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	if u.City == "" {
-		u.City = mockCity()
+	if user.City == "" {
+		user.City = mockCity()
 	}
 
-	if u.WeekDays == "" {
-		u.WeekDays = mockWeekDays()
+	if user.WeekDays == "" {
+		user.WeekDays = mockWeekDays()
 	}
 
-	return u, nil
+	return &user, nil
 }
 
 func mockCity() string {
@@ -105,91 +129,164 @@ func mockWeekDays() string {
 	return weekdays[rand.Intn(len(weekdays))]
 }
 
-func (u *User) CreateUser(user User) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) CreateUser(ctx context.Context, user User) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	// Verificar se o e-mail já está em uso
-	var existingUser User
-	err := db.QueryRowContext(ctx, "SELECT id FROM users WHERE email = $1", user.Email).Scan(&existingUser.ID)
-	if err == nil {
-		return nil, errors.New("E-mail já está em uso")
-	} else if err != sql.ErrNoRows {
+	// O hash é calculado fora da transação para não segurá-la durante o bcrypt
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
 		return nil, err
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	var created User
+	err = WithTx(ctx, func(ctx context.Context) error {
+		// Verificar se o e-mail já está em uso
+		var existingID string
+		err := db.QueryRow(ctx, "SELECT id FROM users WHERE email = $1", user.Email).Scan(&existingID)
+		if err == nil {
+			return &ConstraintError{Kind: ErrConflict, Constraint: "users_email_key", Field: "email", Message: "E-mail já está em uso"}
+		} else if err != pgx.ErrNoRows {
+			return err
+		}
+
+		// Inserir usuário com a nova coluna user_name
+		query := `INSERT INTO users (name, email, password, created_at, updated_at, city, week_days, user_name) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, name, email, created_at, updated_at, city, week_days, user_name`
+		return db.QueryRow(ctx, query, user.Name, user.Email, hashedPassword, time.Now(), time.Now(), user.City, user.WeekDays, user.UserName).Scan(&created.ID, &created.Name, &created.Email, &created.CreatedAt, &created.UpdatedAt, &created.City, &created.WeekDays, &created.UserName)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return &created, nil
+}
+
+// UpdateUser overwrites the user's name and e-mail if the user is still at
+// version (its last update time). A zero version updates unconditionally.
+func (u *User) UpdateUser(ctx context.Context, id string, body User, version time.Time) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	query := `UPDATE users SET name = $1, email = $2, updated_at = $3 WHERE id = $4 AND deleted_at IS NULL AND ($5::timestamptz IS NULL OR updated_at = $5) RETURNING id, name, email, created_at, updated_at, city, week_days, user_name`
+	var updated User
+	err := db.QueryRow(ctx, query, body.Name, body.Email, time.Now(), id, versionArg(version)).Scan(&updated.ID, &updated.Name, &updated.Email, &updated.CreatedAt, &updated.UpdatedAt, &updated.City, &updated.WeekDays, &updated.UserName)
+	if err == pgx.ErrNoRows {
+		return nil, conditionFailed(ctx, "users", id)
+	}
+	if err != nil {
+		return  nil, err
+	}
+	return &updated, nil
+}
 
-	// Inserir usuário com a nova coluna user_name
-	query := `INSERT INTO users (name, email, password, created_at, updated_at, city, week_days, user_name) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, name, email, created_at, updated_at, city, week_days, user_name`
-	err = db.QueryRowContext(ctx, query, user.Name, user.Email, hashedPassword, time.Now(), time.Now(), user.City, user.WeekDays, user.UserName).Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.City, &user.WeekDays, &user.UserName)
+// PatchUser applies patch to the user if it is still at version and returns
+// the stored user. A zero version patches unconditionally.
+func (u *User) PatchUser(ctx context.Context, id string, patch UserPatch, version time.Time) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	var set patchSet
+	set.set("name", patch.Name)
+	set.set("email", patch.Email)
+	set.set("city", patch.City)
+	set.set("week_days", patch.WeekDays)
+	set.set("user_name", patch.UserName)
+	query, args := set.query("users", id, version, "id, name, email, created_at, updated_at, city, week_days, user_name")
+	var user User
+	err := db.QueryRow(ctx, query, args...).Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.City, &user.WeekDays, &user.UserName)
+	if err == pgx.ErrNoRows {
+		return nil, conditionFailed(ctx, "users", id)
+	}
 	if err != nil {
 		return nil, err
 	}
-	user.Password = "" // Limpar a senha antes de retornar
 	return &user, nil
 }
 
+// DeleteUser soft-deletes the user, and their posts and comments with the
+// same timestamp, if the user is still at version. A zero version deletes
+// unconditionally.
+func (u *User) DeleteUser(ctx context.Context, id string, version time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	return WithTx(ctx, func(ctx context.Context) error {
+		deletedAt := time.Now()
+		query := `UPDATE users SET deleted_at = $3 WHERE id = $1 AND deleted_at IS NULL AND ($2::timestamptz IS NULL OR updated_at = $2)`
+		res, err := db.Exec(ctx, query, id, versionArg(version), deletedAt)
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return conditionFailed(ctx, "users", id)
+		}
 
+		query = `UPDATE posts SET deleted_at = $2 WHERE user_id = $1 AND deleted_at IS NULL`
+		_, err = db.Exec(ctx, query, id, deletedAt)
+		if err != nil {
+			return err
+		}
 
-func (u *User) UpdateUser(id string, body User) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-	query := `UPDATE users SET name = $1, email = $2, updated_at = $3 WHERE id = $4`
-	_, err := db.ExecContext(ctx, query, body.Name, body.Email, time.Now(), id)
-	if err != nil {
-		return  nil, err
-	}
-	return &body,nil
+		query = `UPDATE comments SET deleted_at = $2 WHERE user_id = $1 AND deleted_at IS NULL`
+		_, err = db.Exec(ctx, query, id, deletedAt)
+		return err
+	})
 }
 
-func (u *User) DeleteUser(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// RestoreUser undoes the soft deletion of a user along with the posts and
+// comments that were deleted with them.
+func (u *User) RestoreUser(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
-	query := `DELETE FROM users WHERE id = $1`
-	_, err := db.ExecContext(ctx, query, id)
+	query := `
+		WITH target AS (
+			SELECT id, deleted_at FROM users WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE
+		), restored AS (
+			UPDATE users SET deleted_at = NULL FROM target WHERE users.id = target.id
+			RETURNING users.id
+		), restored_posts AS (
+			UPDATE posts SET deleted_at = NULL FROM target
+			WHERE posts.user_id = target.id AND posts.deleted_at = target.deleted_at
+		), restored_comments AS (
+			UPDATE comments SET deleted_at = NULL FROM target
+			WHERE comments.user_id = target.id AND comments.deleted_at = target.deleted_at
+		)
+		SELECT COUNT(*) FROM restored`
+	var n int
+	err := db.QueryRow(ctx, query, id).Scan(&n)
 	if err != nil {
 		return err
 	}
+	if n == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
-func (u *User) GetUserByUsername(username string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, cancel := context.WithTimeout(readOnly(ctx), dbTimeout)
 	defer cancel()
 
-	query := `SELECT id, name, email, created_at, updated_at, city, week_days, user_name 
+	query := `SELECT id, name, email, created_at, updated_at, city, week_days, user_name, ` + followCounts + `
               FROM users 
-              WHERE user_name = $1`
+              WHERE user_name = $1 AND deleted_at IS NULL`
 
-	log.Printf("Executing query: %s with username: %s", query, username)
-
-	row := db.QueryRowContext(ctx, query, username)
+	var user User
+	row := db.QueryRow(ctx, query, username)
 	err := row.Scan(
-		&u.ID,
-		&u.Name,
-		&u.Email,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-		&u.City,
-		&u.WeekDays,
-		&u.UserName,
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.City,
+		&user.WeekDays,
+		&user.UserName,
+		&user.FollowersCount,
+		&user.FollowingCount,
 	)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// Log para usuário não encontrado
-			log.Printf("User with username %s not found", username)
-			return nil, nil // Retorne nil se o usuário não for encontrado
+		if err == pgx.ErrNoRows {
+			logging.FromContext(ctx).Debug("User not found", "user_name", username)
+			return nil, ErrNotFound
 		}
-		// Log para qualquer outro erro
-		log.Printf("Error scanning row for username %s: %v", username, err)
 		return nil, err
 	}
-	// Log para sucesso
-	log.Printf("User found: %+v", u)
-	return u, nil
-}
-
+	return &user, nil
+}
\ No newline at end of file
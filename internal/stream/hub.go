@@ -0,0 +1,321 @@
+// Package stream pushes events to the users connected to this instance as
+// they happen. New posts and notifications are announced by Postgres on the
+// stream_events channel (see the stream_events and stream_event_log
+// migrations), so a write served by any instance reaches the streams of all
+// of them.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"challenge-api/internal/database"
+	"challenge-api/internal/services"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const channel = "stream_events"
+
+// subscriptionBuffer is how many events a subscriber may fall behind before
+// it is dropped. A dropped client reconnects and catches up with
+// Last-Event-ID.
+const subscriptionBuffer = 64
+
+// Hub listens for stream events on a dedicated connection and fans them out
+// to the subscriptions of the users they concern.
+type Hub struct {
+	// Config is the connection the hub listens on. It is kept out of the pool
+	// since it stays busy for the life of the hub.
+	Config *pgx.ConnConfig
+	// Retry paces reconnections after the listening connection is lost.
+	// Attempts is ignored: the hub keeps trying.
+	Retry database.Backoff
+
+	mu     sync.Mutex
+	subs   map[string]map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscription receives the events of one user, of the types it was
+// subscribed to, until Done is closed.
+type Subscription struct {
+	UserID string
+	Events <-chan services.Event
+
+	types  map[string]bool
+	events chan services.Event
+	done   chan struct{}
+}
+
+// Done is closed when the hub drops the subscription: the client fell behind,
+// events may have been missed while the hub reconnected, or the server is
+// shutting down.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Subscribe starts delivering the events of userID of the given types. The
+// subscription must be released with Unsubscribe.
+func (h *Hub) Subscribe(userID string, types ...string) *Subscription {
+	events := make(chan services.Event, subscriptionBuffer)
+	s := &Subscription{UserID: userID, Events: events, types: map[string]bool{}, events: events, done: make(chan struct{})}
+	for _, t := range types {
+		s.types[t] = true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.done)
+		return s
+	}
+	if h.subs == nil {
+		h.subs = make(map[string]map[*Subscription]struct{})
+	}
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*Subscription]struct{})
+	}
+	h.subs[userID][s] = struct{}{}
+	return s
+}
+
+// Unsubscribe stops delivering events to s.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(s)
+}
+
+// drop removes s and closes its Done channel. h.mu must be held.
+func (h *Hub) drop(s *Subscription) {
+	subs, ok := h.subs[s.UserID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[s]; !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(h.subs, s.UserID)
+	}
+	close(s.done)
+}
+
+// dropAll drops every subscription, e.g. when events may have been missed.
+func (h *Hub) dropAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subs := range h.subs {
+		for s := range subs {
+			h.drop(s)
+		}
+	}
+}
+
+// Close drops every subscription and refuses new ones, so the streams end
+// and the server can shut down. A nil Hub is a no-op.
+func (h *Hub) Close() {
+	if h == nil {
+		return
+	}
+	h.dropAll()
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+}
+
+// users returns the IDs of the users with a subscription to eventType.
+func (h *Hub) users(eventType string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var users []string
+	for id := range h.subs {
+		if h.wants(id, eventType) {
+			users = append(users, id)
+		}
+	}
+	return users
+}
+
+// subscribed reports whether userID has a subscription to eventType.
+func (h *Hub) subscribed(userID string, eventType string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.wants(userID, eventType)
+}
+
+// wants reports whether userID has a subscription to eventType. h.mu must be
+// held.
+func (h *Hub) wants(userID string, eventType string) bool {
+	for s := range h.subs[userID] {
+		if s.types[eventType] {
+			return true
+		}
+	}
+	return false
+}
+
+// publish hands event to the subscriptions of userIDs, dropping those that
+// can't keep up.
+func (h *Hub) publish(event services.Event, userIDs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, id := range userIDs {
+		for s := range h.subs[id] {
+			if !s.types[event.Type] {
+				continue
+			}
+			select {
+			case s.events <- event:
+			default:
+				slog.Warn("Stream subscriber fell behind, dropping it", "user_id", id)
+				h.drop(s)
+			}
+		}
+	}
+}
+
+// Run listens for stream events until ctx is canceled, then closes the hub.
+// When the connection is lost it reconnects with backoff, for as long as it
+// takes, and drops the subscriptions so their clients resume from their last
+// event.
+func (h *Hub) Run(ctx context.Context) {
+	defer h.Close()
+	retry := 0
+	for {
+		err := h.listen(ctx, func() { retry = 0 })
+		if ctx.Err() != nil {
+			return
+		}
+		h.dropAll()
+		retry++
+		slog.Warn("Stream listener disconnected, reconnecting", "attempt", retry, "error", err)
+		if err := h.Retry.Wait(ctx, retry); err != nil {
+			return
+		}
+	}
+}
+
+// listen connects, listens on the channel and dispatches notifications until
+// the connection fails or ctx is canceled. connected is called once the
+// listener is up.
+func (h *Hub) listen(ctx context.Context, connected func()) error {
+	conn, err := pgx.ConnectConfig(ctx, h.Config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return err
+	}
+	slog.Info("Listening for stream events")
+	connected()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		h.dispatch(ctx, n.Payload)
+	}
+}
+
+// announcement is the payload of a stream_events notification. ID names the
+// row of the event, or the conversation for typing and read events, and
+// UserID the user behind it. EventID is the position of post and
+// notification events in the stream event log.
+type announcement struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	UserID  string `json:"user_id"`
+	EventID int64  `json:"event_id"`
+}
+
+var events services.Events
+var follows services.Follow
+
+// dispatch loads the row an announcement names, if any subscriber of this
+// instance cares about it, and publishes its event. The subscribers are
+// narrowed down before any row is loaded, since most announcements concern
+// users connected elsewhere. Rows are read from the primary since a replica
+// may not have them yet.
+func (h *Hub) dispatch(ctx context.Context, payload string) {
+	var a announcement
+	if err := json.Unmarshal([]byte(payload), &a); err != nil {
+		slog.Error("Invalid stream event", "payload", payload, "error", err)
+		return
+	}
+	ctx = database.WithPrimary(ctx)
+
+	switch a.Type {
+	case services.EventNotification:
+		if !h.subscribed(a.UserID, a.Type) {
+			return
+		}
+		event, err := events.Notification(ctx, a.EventID, a.ID)
+		if errors.Is(err, services.ErrNotFound) {
+			// Its subject was deleted right away.
+			return
+		}
+		if err != nil {
+			slog.Error("Error loading stream notification", "id", a.ID, "error", err)
+			return
+		}
+		h.publish(*event, a.UserID)
+
+	case services.EventPost:
+		users := h.users(a.Type)
+		if len(users) == 0 {
+			return
+		}
+		followers, err := follows.FollowersAmong(ctx, a.UserID, users)
+		if err != nil {
+			slog.Error("Error finding stream followers", "user_id", a.UserID, "error", err)
+			return
+		}
+		if len(followers) == 0 {
+			return
+		}
+		event, err := events.Post(ctx, a.EventID, a.ID)
+		if errors.Is(err, services.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			slog.Error("Error loading stream post", "id", a.ID, "error", err)
+			return
+		}
+		h.publish(*event, followers...)
+
+	case services.EventMessage, services.EventTyping, services.EventRead:
+		users := h.users(a.Type)
+		if len(users) == 0 {
+			return
+		}
+		var event *services.Event
+		var members []string
+		var err error
+		switch a.Type {
+		case services.EventMessage:
+			event, members, err = events.Message(ctx, a.ID, users)
+		case services.EventTyping:
+			event, members, err = events.Typing(ctx, a.ID, a.UserID, users)
+		case services.EventRead:
+			event, members, err = events.ReadReceipt(ctx, a.ID, a.UserID, users)
+		}
+		if errors.Is(err, services.ErrNotFound) {
+			return
+		}
+		if err != nil {
+			slog.Error("Error loading conversation event", "type", a.Type, "id", a.ID, "error", err)
+			return
+		}
+		if len(members) == 0 {
+			return
+		}
+		h.publish(*event, members...)
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_updated_at_on_rate_limits;
+DROP TABLE IF EXISTS rate_limits;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS rate_limits (
+  "key" TEXT PRIMARY KEY,
+  "tokens" DOUBLE PRECISION NOT NULL,
+  "allowed" BOOLEAN NOT NULL,
+  "updated_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_updated_at_on_rate_limits ON rate_limits(updated_at);
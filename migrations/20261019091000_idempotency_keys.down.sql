@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_expires_at_on_idempotency_keys;
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  "key" TEXT PRIMARY KEY,
+  "fingerprint" TEXT NOT NULL,
+  "status_code" INTEGER,
+  "response_headers" JSONB,
+  "response_body" BYTEA,
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_expires_at_on_idempotency_keys ON idempotency_keys(expires_at);
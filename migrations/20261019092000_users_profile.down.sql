@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_user_name_on_users;
+ALTER TABLE users DROP COLUMN IF EXISTS user_name;
+ALTER TABLE users DROP COLUMN IF EXISTS week_days;
+ALTER TABLE users DROP COLUMN IF EXISTS city;
@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "city" VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "week_days" VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "user_name" VARCHAR(255);
+
+UPDATE users SET user_name = 'user_' || REPLACE(id::text, '-', '') WHERE user_name IS NULL;
+ALTER TABLE users ALTER COLUMN user_name SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_name_on_users ON users(user_name);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_deleted_at_on_albums;
+DROP INDEX IF EXISTS idx_deleted_at_on_posts;
+DROP INDEX IF EXISTS idx_deleted_at_on_users;
+
+ALTER TABLE albums DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE posts DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "deleted_at" TIMESTAMP WITH TIME ZONE;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS "deleted_at" TIMESTAMP WITH TIME ZONE;
+ALTER TABLE albums ADD COLUMN IF NOT EXISTS "deleted_at" TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_deleted_at_on_users ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_deleted_at_on_posts ON posts(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_deleted_at_on_albums ON albums(deleted_at) WHERE deleted_at IS NOT NULL;
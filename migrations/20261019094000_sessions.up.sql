@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS sessions (
+  "token_hash" TEXT PRIMARY KEY,
+  "user_id" UUID NOT NULL,
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_id_on_sessions ON sessions(user_id);
+CREATE INDEX idx_expires_at_on_sessions ON sessions(expires_at);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS comments (
+  "id" UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+  "post_id" UUID NOT NULL,
+  "user_id" UUID NOT NULL,
+  "parent_id" UUID,
+  "content" TEXT NOT NULL CHECK (content <> ''),
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  "updated_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  "deleted_at" TIMESTAMP WITH TIME ZONE,
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE
+);
+
+-- Threads are listed one level at a time, oldest first.
+CREATE INDEX idx_post_id_parent_id_on_comments ON comments(post_id, parent_id, created_at, id);
+CREATE INDEX idx_parent_id_on_comments ON comments(parent_id) WHERE parent_id IS NOT NULL;
+CREATE INDEX idx_user_id_on_comments ON comments(user_id);
+CREATE INDEX idx_deleted_at_on_comments ON comments(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS post_reactions (
+  "post_id" UUID NOT NULL,
+  "user_id" UUID NOT NULL,
+  "type" VARCHAR(16) NOT NULL CHECK (type IN ('like', 'love', 'haha', 'wow', 'sad', 'angry')),
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (post_id, user_id, type),
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_id_on_post_reactions ON post_reactions(user_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS follows (
+  "follower_id" UUID NOT NULL,
+  "followee_id" UUID NOT NULL,
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (follower_id, followee_id),
+  CONSTRAINT follows_no_self_follow CHECK (follower_id <> followee_id),
+  FOREIGN KEY (follower_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (followee_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Both directions are listed newest first.
+CREATE INDEX idx_followee_id_created_at_on_follows ON follows(followee_id, created_at, follower_id);
+CREATE INDEX idx_follower_id_created_at_on_follows ON follows(follower_id, created_at, followee_id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_user_id_created_at_on_posts;
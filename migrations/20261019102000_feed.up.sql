@@ -0,0 +1,3 @@
+-- The feed reads, for each followed user, their newest live posts and merges
+-- them, so each user's posts must be walkable newest first by (created_at, id).
+CREATE INDEX idx_user_id_created_at_on_posts ON posts(user_id, created_at DESC, id DESC) WHERE deleted_at IS NULL;
@@ -0,0 +1,22 @@
+-- Names are stored folded (lower case, accents stripped) so "#Música" and
+-- "#musica" are the same hashtag.
+CREATE TABLE IF NOT EXISTS hashtags (
+  "id" UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+  "name" TEXT NOT NULL UNIQUE CHECK (name <> ''),
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- created_at is the post's, so tagged posts can be listed and counted over a
+-- time window without joining posts first.
+CREATE TABLE IF NOT EXISTS post_hashtags (
+  "post_id" UUID NOT NULL,
+  "hashtag_id" UUID NOT NULL,
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+  PRIMARY KEY (post_id, hashtag_id),
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  FOREIGN KEY (hashtag_id) REFERENCES hashtags(id) ON DELETE CASCADE
+);
+
+-- A hashtag's posts are listed newest first; trending counts recent tags.
+CREATE INDEX idx_hashtag_id_created_at_on_post_hashtags ON post_hashtags(hashtag_id, created_at DESC, post_id DESC);
+CREATE INDEX idx_created_at_on_post_hashtags ON post_hashtags(created_at);
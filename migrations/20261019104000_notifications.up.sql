@@ -0,0 +1,21 @@
+-- A notification tells user_id that actor_id did something involving them.
+-- The subject columns set depend on the type.
+CREATE TABLE IF NOT EXISTS notifications (
+  "id" UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+  "user_id" UUID NOT NULL,
+  "actor_id" UUID NOT NULL,
+  "type" TEXT NOT NULL CONSTRAINT notifications_type_check CHECK (type IN ('mention')),
+  "post_id" UUID,
+  "comment_id" UUID,
+  "album_id" UUID,
+  "read_at" TIMESTAMP WITH TIME ZONE,
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+  FOREIGN KEY (album_id) REFERENCES albums(id) ON DELETE CASCADE
+);
+
+-- Notifications are listed newest first.
+CREATE INDEX idx_user_id_created_at_on_notifications ON notifications(user_id, created_at DESC, id DESC);
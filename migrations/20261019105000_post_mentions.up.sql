@@ -0,0 +1,13 @@
+-- offset and length locate the @user_name token in the post content, in
+-- Unicode code points.
+CREATE TABLE IF NOT EXISTS post_mentions (
+  "post_id" UUID NOT NULL,
+  "user_id" UUID NOT NULL,
+  "offset" INT NOT NULL,
+  "length" INT NOT NULL,
+  PRIMARY KEY (post_id, "offset"),
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_id_on_post_mentions ON post_mentions(user_id);
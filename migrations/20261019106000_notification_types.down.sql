@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_user_id_unread_on_notifications;
+
+DELETE FROM notifications WHERE type <> 'mention';
+ALTER TABLE notifications DROP CONSTRAINT IF EXISTS notifications_type_check;
+ALTER TABLE notifications ADD CONSTRAINT notifications_type_check CHECK (type IN ('mention'));
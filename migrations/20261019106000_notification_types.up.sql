@@ -0,0 +1,5 @@
+ALTER TABLE notifications DROP CONSTRAINT IF EXISTS notifications_type_check;
+ALTER TABLE notifications ADD CONSTRAINT notifications_type_check CHECK (type IN ('follow', 'comment', 'mention', 'album_shared'));
+
+-- The unread count is read on every poll.
+CREATE INDEX idx_user_id_unread_on_notifications ON notifications(user_id) WHERE read_at IS NULL;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS notifications_stream_event ON notifications;
+DROP TRIGGER IF EXISTS posts_stream_event ON posts;
+DROP FUNCTION IF EXISTS notify_stream_event();
@@ -0,0 +1,15 @@
+-- New posts and notifications are announced on the stream_events channel
+-- when their transaction commits, so every API instance can push them to the
+-- streams it serves. The payload only names the row: user_id is the author
+-- of a post and the recipient of a notification.
+CREATE OR REPLACE FUNCTION notify_stream_event() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('stream_events', json_build_object('type', TG_ARGV[0], 'id', NEW.id, 'user_id', NEW.user_id)::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER posts_stream_event AFTER INSERT ON posts
+  FOR EACH ROW EXECUTE FUNCTION notify_stream_event('post');
+CREATE TRIGGER notifications_stream_event AFTER INSERT ON notifications
+  FOR EACH ROW EXECUTE FUNCTION notify_stream_event('notification');
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS messages_stream_event ON messages;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversation_members;
+DROP TABLE IF EXISTS conversations;
@@ -0,0 +1,44 @@
+-- A conversation is private to its members. direct_key is set for 1:1
+-- conversations, to the two member IDs in order, so a pair of users has only
+-- one of them; group conversations leave it NULL.
+CREATE TABLE IF NOT EXISTS conversations (
+  "id" UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+  "title" VARCHAR(255),
+  "direct_key" TEXT UNIQUE,
+  "created_by" UUID NOT NULL,
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  "updated_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- last_read_at is the creation time of the last message the member read.
+CREATE TABLE IF NOT EXISTS conversation_members (
+  "conversation_id" UUID NOT NULL,
+  "user_id" UUID NOT NULL,
+  "last_read_message_id" UUID,
+  "last_read_at" TIMESTAMP WITH TIME ZONE,
+  "joined_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (conversation_id, user_id),
+  FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- user_id is the sender.
+CREATE TABLE IF NOT EXISTS messages (
+  "id" UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+  "conversation_id" UUID NOT NULL,
+  "user_id" UUID NOT NULL,
+  "content" TEXT NOT NULL CHECK (content <> ''),
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Conversations are listed by latest activity and history newest first.
+CREATE INDEX idx_user_id_on_conversation_members ON conversation_members(user_id);
+CREATE INDEX idx_updated_at_on_conversations ON conversations(updated_at DESC, id DESC);
+CREATE INDEX idx_conversation_id_created_at_on_messages ON messages(conversation_id, created_at DESC, id DESC);
+
+-- New messages go out on the stream_events channel like posts do.
+CREATE TRIGGER messages_stream_event AFTER INSERT ON messages
+  FOR EACH ROW EXECUTE FUNCTION notify_stream_event('message');
@@ -0,0 +1,12 @@
+-- A post revision keeps a version of a post's content that an edit replaced.
+-- Revisions are numbered from 1 per post; created_at is when the version was
+-- written and replaced_at when it stopped being current.
+CREATE TABLE IF NOT EXISTS post_revisions (
+  "post_id" UUID NOT NULL,
+  "revision" INT NOT NULL,
+  "content" TEXT NOT NULL,
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+  "replaced_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (post_id, revision),
+  FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS notifications_stream_event ON notifications;
+DROP TRIGGER IF EXISTS posts_stream_event ON posts;
+CREATE TRIGGER posts_stream_event AFTER INSERT ON posts
+  FOR EACH ROW EXECUTE FUNCTION notify_stream_event('post');
+CREATE TRIGGER notifications_stream_event AFTER INSERT ON notifications
+  FOR EACH ROW EXECUTE FUNCTION notify_stream_event('notification');
+DROP FUNCTION IF EXISTS log_stream_event();
+DROP TABLE IF EXISTS stream_events;
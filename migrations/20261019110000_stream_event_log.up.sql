@@ -0,0 +1,39 @@
+-- Posts and notifications are logged as stream events so a client can resume
+-- its stream after the last event it saw. An event gets its id right before
+-- its transaction commits, while holding a lock kept until the commit is
+-- done, so ids follow commit order: once a client has seen event n, no event
+-- below n can still show up. Only the commits of transactions logging events
+-- are serialized, and only for the end of the commit.
+CREATE TABLE IF NOT EXISTS stream_events (
+  "id" BIGSERIAL PRIMARY KEY,
+  "type" TEXT NOT NULL,
+  "subject_id" UUID NOT NULL,
+  "user_id" UUID NOT NULL,
+  "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_id_id_on_stream_events ON stream_events(user_id, id);
+CREATE INDEX idx_created_at_on_stream_events ON stream_events(created_at);
+
+-- Like notify_stream_event, with the id of the logged event in the payload.
+CREATE OR REPLACE FUNCTION log_stream_event() RETURNS trigger AS $$
+DECLARE
+  event_id BIGINT;
+BEGIN
+  PERFORM pg_advisory_xact_lock(hashtext('stream_events'));
+  INSERT INTO stream_events (type, subject_id, user_id) VALUES (TG_ARGV[0], NEW.id, NEW.user_id)
+    RETURNING id INTO event_id;
+  PERFORM pg_notify('stream_events', json_build_object('type', TG_ARGV[0], 'id', NEW.id, 'user_id', NEW.user_id, 'event_id', event_id)::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Deferred constraint triggers run when the transaction commits.
+DROP TRIGGER IF EXISTS posts_stream_event ON posts;
+DROP TRIGGER IF EXISTS notifications_stream_event ON notifications;
+CREATE CONSTRAINT TRIGGER posts_stream_event AFTER INSERT ON posts
+  DEFERRABLE INITIALLY DEFERRED
+  FOR EACH ROW EXECUTE FUNCTION log_stream_event('post');
+CREATE CONSTRAINT TRIGGER notifications_stream_event AFTER INSERT ON notifications
+  DEFERRABLE INITIALLY DEFERRED
+  FOR EACH ROW EXECUTE FUNCTION log_stream_event('notification');
@@ -23,7 +23,7 @@ with conn.cursor() as cur:
     for i in range(50):
         user_name = f'User_{uuid.uuid4()}'
         user_email = f'{user_name.lower()}@example.com'
-        cur.execute("INSERT INTO users (name, email, password) VALUES (%s, %s, 'password') RETURNING id;", (user_name, user_email))
+        cur.execute("INSERT INTO users (name, email, password, user_name) VALUES (%s, %s, 'password', %s) RETURNING id;", (user_name, user_email, user_name.lower()))
         user_id = cur.fetchone()[0]
 
         post_content = f'Post content by {user_name}'